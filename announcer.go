@@ -0,0 +1,248 @@
+package bytedribble
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Announcer announces the local peer to a single tracker.
+type Announcer interface {
+	Announce(ctx context.Context, req AnnounceRequest) (AnnounceResponse, error)
+}
+
+// AnnouncerFactory creates the Announcer for a tracker URL.
+type AnnouncerFactory func(u *url.URL) (Announcer, error)
+
+// TrackerRegistry selects how to reach a tracker by the scheme of its URL.
+type TrackerRegistry struct {
+	mu        sync.Mutex
+	factories map[string]AnnouncerFactory
+	memory    map[string]*MemoryTracker // by host, for memory:// URLs
+}
+
+// DefaultTrackerRegistry is the registry used by TrackerClient when none is given.
+var DefaultTrackerRegistry = NewTrackerRegistry(http.DefaultClient)
+
+// NewTrackerRegistry returns a registry supporting http, https and udp trackers, and memory trackers that live in this
+// registry. HTTP trackers are reached with client. memory://name always refers to the same MemoryTracker, so any
+// TrackerClient using the registry can share a swarm through it.
+func NewTrackerRegistry(client *http.Client) *TrackerRegistry {
+	r := &TrackerRegistry{
+		factories: make(map[string]AnnouncerFactory),
+		memory:    make(map[string]*MemoryTracker),
+	}
+	httpFactory := func(u *url.URL) (Announcer, error) {
+		return NewHTTPTracker(client, u), nil
+	}
+	r.Register("http", httpFactory)
+	r.Register("https", httpFactory)
+	r.Register("udp", func(u *url.URL) (Announcer, error) {
+		return NewUDPTracker(u), nil
+	})
+	r.Register("memory", func(u *url.URL) (Announcer, error) {
+		return r.MemoryTracker(u.Host), nil
+	})
+	return r
+}
+
+// Register makes the registry use factory for tracker URLs with the given scheme, replacing any previous factory.
+func (r *TrackerRegistry) Register(scheme string, factory AnnouncerFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[scheme] = factory
+}
+
+// Announcer returns a new Announcer for the tracker at u.
+func (r *TrackerRegistry) Announcer(u *url.URL) (Announcer, error) {
+	r.mu.Lock()
+	factory, ok := r.factories[u.Scheme]
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+	return factory(u)
+}
+
+// MemoryTracker returns the memory tracker reached by memory://name, creating it if needed.
+func (r *TrackerRegistry) MemoryTracker(name string) *MemoryTracker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.memory[name]
+	if !ok {
+		t = NewMemoryTracker(time.Minute)
+		r.memory[name] = t
+	}
+	return t
+}
+
+// defaultNumWant is the number of peers returned when an announce does not ask for a specific number.
+const defaultNumWant = 50
+
+// MemoryTracker is a tracker that keeps its swarms in memory and is announced to directly, without a network. It is
+// mostly useful for tests.
+//
+// Like tracker.Swarms, peers are identified by their peer id and IP address, and are forgotten once they miss two
+// announces. Download counts outlive the peers of a swarm.
+type MemoryTracker struct {
+	interval    time.Duration
+	peerTimeout time.Duration
+	now         func() time.Time
+
+	mu         sync.Mutex
+	swarms     map[string]map[memoryPeerKey]*memoryPeer // by infohash
+	downloaded map[string]int                           // completed events, by infohash
+	swept      time.Time                                // when every swarm was last expired
+}
+
+// memoryPeerKey identifies a peer in a swarm. The IP address is part of it so that no one can stop or move another
+// host's peer by announcing with its peer id.
+type memoryPeerKey struct {
+	id PeerID
+	ip string
+}
+
+type memoryPeer struct {
+	info     PeerInfo
+	left     int64
+	lastSeen time.Time
+}
+
+// NewMemoryTracker returns an empty tracker asking peers to announce every interval. Peers are forgotten if they have
+// not announced for two intervals, or two seconds if the interval is shorter than a second.
+func NewMemoryTracker(interval time.Duration) *MemoryTracker {
+	timeout := interval
+	if timeout < minAnnounceInterval {
+		// clients announce at least this often, whatever they are told
+		timeout = minAnnounceInterval
+	}
+	return &MemoryTracker{
+		interval:    interval,
+		peerTimeout: 2 * timeout,
+		now:         time.Now,
+		swarms:      make(map[string]map[memoryPeerKey]*memoryPeer),
+		downloaded:  make(map[string]int),
+	}
+}
+
+// Announce records the peer in the torrent's swarm, or removes it if it stopped, and returns the swarm's other peers.
+// Peers without an IP address are recorded as announcing from the loopback address.
+func (t *MemoryTracker) Announce(ctx context.Context, req AnnounceRequest) (AnnounceResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return AnnounceResponse{}, err
+	}
+	ip := req.IP
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	self := PeerInfo{PeerID: req.PeerID, IP: ip, Port: req.Port}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	infoHash := string(req.InfoHash)
+	swarm := t.lookup(infoHash, now)
+	if swarm == nil {
+		if req.Event == Stopped {
+			// nothing to forget
+			return AnnounceResponse{Interval: t.interval}, nil
+		}
+		swarm = make(map[memoryPeerKey]*memoryPeer)
+		t.swarms[infoHash] = swarm
+	}
+	key := memoryPeerKey{id: req.PeerID, ip: ip.String()}
+	p, known := swarm[key]
+	switch req.Event {
+	case Stopped:
+		delete(swarm, key)
+		if len(swarm) == 0 {
+			delete(t.swarms, infoHash)
+		}
+	default:
+		if req.Event == Completed && (!known || p.left != 0) {
+			// repeated completed events from a peer are only counted once
+			t.downloaded[infoHash]++
+		}
+		swarm[key] = &memoryPeer{info: self, left: req.Left, lastSeen: now}
+	}
+
+	resp := AnnounceResponse{Interval: t.interval}
+	numWant := req.NumWant
+	if numWant < 0 {
+		numWant = defaultNumWant
+	}
+	for k, p := range swarm {
+		if p.left == 0 {
+			resp.Seeders++
+		} else {
+			resp.Leechers++
+		}
+		if k != key && req.Event != Stopped && len(resp.Peers) < numWant {
+			resp.Peers = append(resp.Peers, p.info)
+		}
+	}
+	return resp, nil
+}
+
+// Scrape returns the statistics of each of the torrents, in order.
+func (t *MemoryTracker) Scrape(ctx context.Context, infoHashes ...[]byte) ([]ScrapeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	results := make([]ScrapeResult, len(infoHashes))
+	for i, h := range infoHashes {
+		for _, p := range t.lookup(string(h), now) {
+			if p.left == 0 {
+				results[i].Complete++
+			} else {
+				results[i].Incomplete++
+			}
+		}
+		results[i].Downloaded = t.downloaded[string(h)]
+	}
+	return results, nil
+}
+
+// lookup returns the swarm of a torrent without its expired peers, or nil if it has no peers left. t.mu must be held.
+func (t *MemoryTracker) lookup(infoHash string, now time.Time) map[memoryPeerKey]*memoryPeer {
+	t.sweep(now)
+	swarm, ok := t.swarms[infoHash]
+	if !ok || !t.expire(infoHash, swarm, now) {
+		return nil
+	}
+	return swarm
+}
+
+// sweep expires the peers of every swarm once per peer timeout, so that swarms which are no longer announced to are
+// eventually forgotten too. t.mu must be held.
+func (t *MemoryTracker) sweep(now time.Time) {
+	if now.Sub(t.swept) < t.peerTimeout {
+		return
+	}
+	t.swept = now
+	for h, swarm := range t.swarms {
+		t.expire(h, swarm, now)
+	}
+}
+
+// expire removes the peers of a swarm that have not announced within the peer timeout, and then the swarm itself if it
+// has no peers left. It reports whether the swarm remains. t.mu must be held.
+func (t *MemoryTracker) expire(infoHash string, swarm map[memoryPeerKey]*memoryPeer, now time.Time) bool {
+	for k, p := range swarm {
+		if now.Sub(p.lastSeen) > t.peerTimeout {
+			delete(swarm, k)
+		}
+	}
+	if len(swarm) == 0 {
+		delete(t.swarms, infoHash)
+		return false
+	}
+	return true
+}
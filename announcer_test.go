@@ -0,0 +1,170 @@
+package bytedribble
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// recordingAnnouncer responds to every announce with a fixed peer and remembers the requests.
+type recordingAnnouncer struct {
+	requests []AnnounceRequest
+}
+
+func (a *recordingAnnouncer) Announce(ctx context.Context, req AnnounceRequest) (AnnounceResponse, error) {
+	a.requests = append(a.requests, req)
+	return AnnounceResponse{
+		Interval: time.Minute,
+		Peers:    []PeerInfo{{IP: net.IPv4(10, 0, 0, 1), Port: 6881}},
+	}, nil
+}
+
+func TestTrackerRegistry_CustomScheme(t *testing.T) {
+	registry := NewTrackerRegistry(nil)
+	announcer := &recordingAnnouncer{}
+	var created int
+	registry.Register("test", func(u *url.URL) (Announcer, error) {
+		created++
+		assert.Equal(t, "swarm", u.Host)
+		return announcer, nil
+	})
+
+	u, _ := url.Parse("test://swarm/announce")
+	meta := Metainfo{TrackerURL: u, RawInfo: []byte("de")}
+	c := NewTrackerClient(registry, meta, PeerInfo{Port: 6881}, FakeMetrics{TotalSize: 10})
+	for _, event := range []Event{Started, Empty} {
+		interval, err := c.syncTracker(context.Background(), event)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Minute, interval)
+	}
+	assert.Len(t, c.Peers(), 1)
+	assert.Equal(t, 1, created, "announcers should be reused")
+	if assert.Len(t, announcer.requests, 2) {
+		assert.Equal(t, Started, announcer.requests[0].Event)
+		assert.Equal(t, int64(10), announcer.requests[0].Left)
+		assert.Equal(t, meta.InfoHash(), announcer.requests[0].InfoHash)
+	}
+}
+
+func TestTrackerRegistry_UnknownScheme(t *testing.T) {
+	u, _ := url.Parse("wss://tracker/announce")
+	_, err := NewTrackerRegistry(nil).Announcer(u)
+	assert.ErrorContains(t, err, "unsupported tracker scheme")
+}
+
+func TestMemoryTracker(t *testing.T) {
+	registry := NewTrackerRegistry(nil)
+	u, _ := url.Parse("memory://swarm")
+	meta := Metainfo{TrackerURL: u, RawInfo: []byte("de")}
+	seeder := NewTrackerClient(registry, meta, PeerInfo{PeerID: PeerID{1}, Port: 1}, FakeMetrics{})
+	leecher := NewTrackerClient(registry, meta, PeerInfo{PeerID: PeerID{2}, Port: 2}, FakeMetrics{TotalSize: 10})
+
+	peers, err := seeder.RequestNewPeers(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+	peers, err = leecher.RequestNewPeers(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, "127.0.0.1:1", peers[0].Addr())
+	}
+
+	tracker := registry.MemoryTracker("swarm")
+	resp, err := tracker.Announce(context.Background(), AnnounceRequest{InfoHash: meta.InfoHash(), PeerID: PeerID{3}, Port: 3, Left: 1, NumWant: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Seeders)
+	assert.Equal(t, 2, resp.Leechers)
+	assert.Len(t, resp.Peers, 1)
+
+	assert.NoError(t, seeder.Stopped(context.Background()))
+	peers, err = leecher.RequestNewPeers(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, "127.0.0.1:3", peers[0].Addr())
+	}
+}
+
+func TestMemoryTracker_Scrape(t *testing.T) {
+	tracker := NewMemoryTracker(time.Minute)
+	hash := make([]byte, 20)
+	for i, req := range []AnnounceRequest{
+		{PeerID: PeerID{1}, Port: 1, Left: 10, Event: Started},
+		{PeerID: PeerID{2}, Port: 2, Left: 10, Event: Started},
+		{PeerID: PeerID{1}, Port: 1, Left: 0, Event: Completed},
+		{PeerID: PeerID{1}, Port: 1, Left: 0, Event: Completed},
+	} {
+		req.InfoHash = hash
+		_, err := tracker.Announce(context.Background(), req)
+		assert.NoError(t, err, "announce %d", i)
+	}
+	results, err := tracker.Scrape(context.Background(), hash, []byte("unknown"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ScrapeResult{{Complete: 1, Downloaded: 1, Incomplete: 1}, {}}, results)
+	}
+}
+
+func TestMemoryTracker_PeerIdentity(t *testing.T) {
+	tracker := NewMemoryTracker(time.Minute)
+	hash := make([]byte, 20)
+	announce := func(req AnnounceRequest) AnnounceResponse {
+		req.InfoHash = hash
+		resp, err := tracker.Announce(context.Background(), req)
+		assert.NoError(t, err)
+		return resp
+	}
+	announce(AnnounceRequest{PeerID: PeerID{1}, IP: net.IPv4(10, 0, 0, 1), Port: 1, Left: 1})
+	// another host cannot stop a peer by announcing with its peer id
+	announce(AnnounceRequest{PeerID: PeerID{1}, IP: net.IPv4(10, 0, 0, 2), Port: 1, Left: 1, Event: Stopped})
+	// a peer moving to another port is not counted twice
+	resp := announce(AnnounceRequest{PeerID: PeerID{1}, IP: net.IPv4(10, 0, 0, 1), Port: 2, Left: 1})
+	assert.Equal(t, 1, resp.Leechers)
+
+	// stopping the last peer forgets the swarm, and stopping again does not bring it back
+	announce(AnnounceRequest{PeerID: PeerID{1}, IP: net.IPv4(10, 0, 0, 1), Port: 2, Event: Stopped})
+	announce(AnnounceRequest{PeerID: PeerID{1}, IP: net.IPv4(10, 0, 0, 1), Port: 2, Event: Stopped})
+	assert.Empty(t, tracker.swarms)
+}
+
+func TestMemoryTracker_Expiry(t *testing.T) {
+	tracker := NewMemoryTracker(time.Minute)
+	now := time.Unix(1000, 0)
+	tracker.now = func() time.Time { return now }
+	hash := make([]byte, 20)
+	_, err := tracker.Announce(context.Background(), AnnounceRequest{InfoHash: hash, PeerID: PeerID{1}, Port: 1, Event: Completed})
+	assert.NoError(t, err)
+
+	now = now.Add(2*time.Minute + time.Second)
+	resp, err := tracker.Announce(context.Background(), AnnounceRequest{InfoHash: hash, PeerID: PeerID{2}, Port: 2, Left: 1})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Peers)
+	assert.Equal(t, 0, resp.Seeders)
+
+	// swarms that are no longer announced to are forgotten, but not how often they were downloaded
+	now = now.Add(5 * time.Minute)
+	results, err := tracker.Scrape(context.Background(), []byte("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, []ScrapeResult{{}}, results)
+	assert.Empty(t, tracker.swarms)
+	results, err = tracker.Scrape(context.Background(), hash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ScrapeResult{{Downloaded: 1}}, results)
+	}
+}
+
+func TestTrackerClient_ZeroInterval(t *testing.T) {
+	registry := NewTrackerRegistry(nil)
+	registry.Register("memory", func(u *url.URL) (Announcer, error) {
+		return NewMemoryTracker(0), nil
+	})
+	u, _ := url.Parse("memory://swarm")
+	c := NewTrackerClient(registry, Metainfo{TrackerURL: u, RawInfo: []byte("de")}, PeerInfo{Port: 1}, FakeMetrics{})
+	interval, err := c.syncTracker(context.Background(), Started)
+	assert.NoError(t, err)
+	assert.Equal(t, minAnnounceInterval, interval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Run(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,145 @@
+package bencoding
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// field describes how a struct field maps to a dictionary key.
+type field struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+type structFields struct {
+	sorted []field // sorted by name, as required when encoding
+	byName map[string]*field
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields returns the dictionary keys of struct type t. Fields of untagged embedded structs are promoted into the
+// parent dictionary. When several fields map to the same key, the shallowest one wins.
+func typeFields(t reflect.Type) *structFields {
+	var fields []field
+	seen := make(map[string]bool)
+
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+	current := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(current) > 0 {
+		var next []queued
+		var level []field
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+				tag := sf.Tag.Get("bencode")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				ft := sf.Type
+				if sf.Anonymous && name == "" {
+					if ft.Kind() == reflect.Pointer {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						next = append(next, queued{typ: ft, index: index})
+						continue
+					}
+				}
+				if !sf.IsExported() {
+					continue
+				}
+				if name == "" {
+					name = sf.Name
+				}
+				level = append(level, field{
+					name:      name,
+					index:     index,
+					typ:       sf.Type,
+					omitEmpty: opts == "omitempty",
+				})
+			}
+		}
+		for _, f := range level {
+			if seen[f.name] {
+				continue
+			}
+			seen[f.name] = true
+			fields = append(fields, f)
+		}
+		current = next
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+	sf := &structFields{
+		sorted: fields,
+		byName: make(map[string]*field, len(fields)),
+	}
+	for i := range sf.sorted {
+		sf.byName[sf.sorted[i].name] = &sf.sorted[i]
+	}
+	return sf
+}
+
+// fieldPath tracks the location of the value being processed, for use in error messages.
+type fieldPath []string
+
+func (p *fieldPath) pushKey(key string) {
+	*p = append(*p, key)
+}
+
+func (p *fieldPath) pushIndex(i int) {
+	*p = append(*p, "["+strconv.Itoa(i)+"]")
+}
+
+func (p *fieldPath) pop() {
+	*p = (*p)[:len(*p)-1]
+}
+
+// joinPath appends a path formatted by fieldPath.String to another.
+func joinPath(parent, child string) string {
+	if parent == "" || child == "" || strings.HasPrefix(child, "[") {
+		return parent + child
+	}
+	return parent + "." + child
+}
+
+// String formats the path like "info.files[3].path".
+func (p fieldPath) String() string {
+	var b strings.Builder
+	for i, elem := range p {
+		if i > 0 && !strings.HasPrefix(elem, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(elem)
+	}
+	return b.String()
+}
@@ -1,93 +1,262 @@
 // Package bencoding implements the encoding used by bittorrent metadata and protocols.
 //
+// Values are mapped to and from Go values in the style of encoding/json. Struct fields are encoded as dictionary
+// entries named after the field, or after the first part of the field's `bencode` tag:
+//
+//	Length int      `bencode:"length"`            // key "length"
+//	MD5Sum string   `bencode:"md5sum,omitempty"`  // key "md5sum", omitted if empty
+//	Private bool    `bencode:"private,omitempty"` // encoded as i1e, omitted if false
+//	Ignored string  `bencode:"-"`                 // never encoded or decoded
+//
 // See: https://www.bittorrent.org/beps/bep_0003.html#bencoding
 package bencoding
 
 import (
 	"bytes"
+	"fmt"
+	"math/big"
 	"reflect"
 	"sort"
 	"strconv"
 )
 
-func Marshal(input any) []byte {
-	bs := &bytes.Buffer{}
-	marshal(bs, input)
-	return bs.Bytes()
-}
-
-func marshal(bs *bytes.Buffer, input any) {
-	switch in := input.(type) {
-	case string:
-		marshalString(bs, in)
-	case int:
-		marshalInt(bs, in)
-	case []string:
-		marshalList(bs, in)
-	case []int:
-		marshalList(bs, in)
-	case map[string]any:
-		marshalDict(bs, in)
-	default:
-		panic("unable to marshal type: " + reflect.ValueOf(input).Type().Name())
+// Marshal returns the bencoding of v.
+//
+// Strings, byte slices and byte arrays are encoded as byte strings. Integers, big.Ints and booleans (as 0 or 1) are
+// encoded as integers. Slices and arrays are encoded as lists. Maps with string keys and structs are encoded as
+// dictionaries with their keys sorted. Nil pointers and interfaces are omitted from dictionaries and cannot be encoded
+// anywhere else.
+func Marshal(v any) ([]byte, error) {
+	e := &encodeState{}
+	if err := e.marshal(reflect.ValueOf(v)); err != nil {
+		return nil, err
 	}
+	return e.Bytes(), nil
 }
 
+// MarshalString returns the bencoding of a single byte string.
 func MarshalString(s string) []byte {
-	bs := &bytes.Buffer{}
-	marshalString(bs, s)
-	return bs.Bytes()
+	e := &encodeState{}
+	e.string(s)
+	return e.Bytes()
+}
+
+// MarshalInt returns the bencoding of a single integer.
+func MarshalInt(i int64) []byte {
+	e := &encodeState{}
+	e.int(i)
+	return e.Bytes()
+}
+
+// An UnsupportedTypeError is returned by Marshal when attempting to encode a value with no bencoded representation.
+type UnsupportedTypeError struct {
+	Type  reflect.Type
+	Field string // the full path to the value, if any
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Field != "" {
+		return "bencoding: unsupported type " + e.Type.String() + " for field " + e.Field
+	}
+	return "bencoding: unsupported type " + e.Type.String()
+}
+
+type encodeState struct {
+	bytes.Buffer
+	path fieldPath
+}
+
+func (e *encodeState) marshal(v reflect.Value) error {
+	if !v.IsValid() {
+		return &UnsupportedTypeError{Type: reflect.TypeOf((*any)(nil)).Elem(), Field: e.path.String()}
+	}
+	if v.Kind() != reflect.Interface && !isNilValue(v) && v.Type().Implements(marshalerType) {
+		return e.marshaler(v.Interface().(Marshaler), v.Type())
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() && reflect.PointerTo(v.Type()).Implements(marshalerType) {
+		return e.marshaler(v.Addr().Interface().(Marshaler), v.Type())
+	}
+	if v.Type() == bigIntType {
+		n := reflect.New(bigIntType)
+		n.Elem().Set(v)
+		e.WriteByte('i')
+		e.WriteString(n.Interface().(*big.Int).String())
+		e.WriteByte('e')
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			e.int(1)
+		} else {
+			e.int(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.WriteByte('i')
+		e.WriteString(strconv.FormatUint(v.Uint(), 10))
+		e.WriteByte('e')
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &UnsupportedTypeError{Type: v.Type(), Field: e.path.String()}
+		}
+		return e.marshal(v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bytes(v.Bytes())
+			return nil
+		}
+		return e.list(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.bytes(b)
+			return nil
+		}
+		return e.list(v)
+	case reflect.Map:
+		return e.dict(v)
+	case reflect.Struct:
+		return e.structDict(v)
+	default:
+		return &UnsupportedTypeError{Type: v.Type(), Field: e.path.String()}
+	}
+	return nil
+}
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	bigIntType    = reflect.TypeOf(big.Int{})
+)
+
+func (e *encodeState) marshaler(m Marshaler, t reflect.Type) error {
+	b, err := m.MarshalBencode()
+	if err != nil {
+		return fmt.Errorf("bencoding: error calling MarshalBencode for type %s: %w", t, err)
+	}
+	d := &decodeState{data: b}
+	if err := d.value(reflect.Value{}); err != nil || d.off != len(b) {
+		return fmt.Errorf("bencoding: MarshalBencode for type %s returned invalid bencoding", t)
+	}
+	e.Write(b)
+	return nil
 }
 
-func marshalString(bs *bytes.Buffer, s string) {
-	bs.WriteString(strconv.Itoa(len(s)))
-	bs.WriteString(":")
-	bs.WriteString(s)
+func (e *encodeState) string(s string) {
+	e.WriteString(strconv.Itoa(len(s)))
+	e.WriteByte(':')
+	e.WriteString(s)
 }
 
-func MarshalInt(i int) []byte {
-	bs := &bytes.Buffer{}
-	marshalInt(bs, i)
-	return bs.Bytes()
+func (e *encodeState) bytes(b []byte) {
+	e.WriteString(strconv.Itoa(len(b)))
+	e.WriteByte(':')
+	e.Write(b)
 }
 
-func marshalInt(bs *bytes.Buffer, i int) {
-	bs.WriteString("i")
-	bs.WriteString(strconv.Itoa(i))
-	bs.WriteString("e")
+func (e *encodeState) int(i int64) {
+	e.WriteByte('i')
+	e.WriteString(strconv.FormatInt(i, 10))
+	e.WriteByte('e')
 }
 
-func MarshalList[T string | int](l []T) []byte {
-	bs := &bytes.Buffer{}
-	marshalList(bs, l)
-	return bs.Bytes()
+func (e *encodeState) list(v reflect.Value) error {
+	e.WriteByte('l')
+	for i := 0; i < v.Len(); i++ {
+		e.path.pushIndex(i)
+		if err := e.marshal(v.Index(i)); err != nil {
+			return err
+		}
+		e.path.pop()
+	}
+	e.WriteByte('e')
+	return nil
 }
 
-func marshalList[T string | int](bs *bytes.Buffer, l []T) {
-	bs.WriteString("l")
-	for _, elem := range l {
-		marshal(bs, elem)
+func (e *encodeState) dict(v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return &UnsupportedTypeError{Type: v.Type(), Field: e.path.String()}
+	}
+	keys := make([]string, 0, v.Len())
+	for iter := v.MapRange(); iter.Next(); {
+		keys = append(keys, iter.Key().String())
 	}
-	bs.WriteString("e")
+	sort.Strings(keys)
+
+	e.WriteByte('d')
+	for _, k := range keys {
+		elem := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+		if isNilValue(elem) {
+			continue
+		}
+		e.string(k)
+		e.path.pushKey(k)
+		if err := e.marshal(elem); err != nil {
+			return err
+		}
+		e.path.pop()
+	}
+	e.WriteByte('e')
+	return nil
 }
 
-func MarshalDict(d map[string]any) []byte {
-	bs := &bytes.Buffer{}
-	marshalDict(bs, d)
-	return bs.Bytes()
+func (e *encodeState) structDict(v reflect.Value) error {
+	e.WriteByte('d')
+	for _, f := range cachedFields(v.Type()).sorted {
+		elem, ok := fieldByIndex(v, f.index)
+		if !ok || isNilValue(elem) || (f.omitEmpty && isEmptyValue(elem)) {
+			continue
+		}
+		e.string(f.name)
+		e.path.pushKey(f.name)
+		if err := e.marshal(elem); err != nil {
+			return err
+		}
+		e.path.pop()
+	}
+	e.WriteByte('e')
+	return nil
 }
 
-func marshalDict(bs *bytes.Buffer, d map[string]any) {
-	var sortedKeys []string
-	for k := range d {
-		sortedKeys = append(sortedKeys, k)
+// fieldByIndex returns the (possibly embedded) field of v. It reports false if the field is reached through a nil
+// embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
 	}
-	sort.Strings(sortedKeys)
+	return v, true
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return !v.IsValid()
+}
 
-	bs.WriteString("d")
-	for _, k := range sortedKeys {
-		marshalString(bs, k)
-		marshal(bs, d[k])
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
 	}
-	bs.WriteString("e")
+	return false
 }
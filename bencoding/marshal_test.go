@@ -65,7 +65,91 @@ func TestMarshal(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.want, Marshal(tt.in))
+			got, err := Marshal(tt.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
 		})
 	}
 }
+
+type testFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	MD5Sum string   `bencode:"md5sum,omitempty"`
+}
+
+type testInfo struct {
+	Name    string     `bencode:"name"`
+	Pieces  []byte     `bencode:"pieces"`
+	Files   []testFile `bencode:"files,omitempty"`
+	Private bool       `bencode:"private,omitempty"`
+	Ignored string     `bencode:"-"`
+}
+
+type testMetainfo struct {
+	Announce string            `bencode:"announce"`
+	Info     *testInfo         `bencode:"info"`
+	Extra    map[string]string `bencode:"extra,omitempty"`
+	Comment  *string           `bencode:"comment"`
+}
+
+func TestMarshal_Struct(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+		want []byte
+	}{
+		{
+			name: "tags, nesting and sorted keys",
+			in: testMetainfo{
+				Announce: "http://tracker",
+				Info: &testInfo{
+					Name:    "dir",
+					Pieces:  []byte{0, 1, 2},
+					Files:   []testFile{{Length: 3, Path: []string{"a", "b"}}},
+					Private: true,
+					Ignored: "not encoded",
+				},
+			},
+			want: []byte("d8:announce14:http://tracker4:infod5:filesld6:lengthi3e4:pathl1:a1:beee4:name3:dir6:pieces3:\x00\x01\x027:privatei1eee"),
+		},
+		{
+			name: "omitempty",
+			in:   testInfo{Name: "file", Pieces: []byte{}},
+			want: []byte("d4:name4:file6:pieces0:e"),
+		},
+		{
+			name: "untagged and embedded fields",
+			in: struct {
+				testFile
+				Name string
+			}{testFile{Length: 1, Path: []string{}}, "x"},
+			want: []byte("d4:Name1:x6:lengthi1e4:pathlee"),
+		},
+		{
+			name: "byte array",
+			in:   [4]byte{'a', 'b', 'c', 'd'},
+			want: []byte("4:abcd"),
+		},
+		{
+			name: "nested lists of dicts",
+			in:   []any{map[string]any{"a": []any{1, "b"}}, []int{}},
+			want: []byte("ld1:ali1e1:beelee"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMarshal_UnsupportedType(t *testing.T) {
+	_, err := Marshal(map[string]any{"info": map[string]any{"files": []any{1.5}}})
+	var typeErr *UnsupportedTypeError
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, "info.files[0]", typeErr.Field)
+	}
+}
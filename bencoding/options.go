@@ -0,0 +1,70 @@
+package bencoding
+
+import "strconv"
+
+// DecodeOptions configures how input is decoded. The zero value decodes leniently and without limits, which is only
+// appropriate for trusted input. Limits left at zero are not enforced.
+type DecodeOptions struct {
+	// Strict rejects input that is not canonically bencoded. See UnmarshalStrict.
+	Strict bool
+	// UseBytes decodes byte strings stored in interface values (and returned by Decoder.Token) as []byte rather
+	// than string.
+	UseBytes bool
+
+	// MaxDepth limits how deeply lists and dictionaries may be nested. A top-level list has depth 1.
+	MaxDepth int
+	// MaxStringLength limits the declared length of any byte string, including dictionary keys.
+	MaxStringLength int
+	// MaxTotalBytes limits the encoded size of each top-level value.
+	MaxTotalBytes int64
+	// MaxDictEntries limits the number of entries in any one dictionary.
+	MaxDictEntries int
+}
+
+// A LimitError is returned when decoding input that exceeds one of the limits set in DecodeOptions.
+type LimitError struct {
+	Limit  string // name of the DecodeOptions field, like "MaxDepth"
+	Max    int64  // value of the limit
+	Offset int64  // offset in the input at which the limit was exceeded
+	Path   string // the full path to the value being decoded
+}
+
+func (e *LimitError) Error() string {
+	msg := "bencoding: input exceeds " + e.Limit + " (" + strconv.FormatInt(e.Max, 10) + ") at offset " +
+		strconv.FormatInt(e.Offset, 10)
+	if e.Path != "" {
+		msg += " (" + e.Path + ")"
+	}
+	return msg
+}
+
+// UnmarshalWithOptions is like Unmarshal but decodes according to opts.
+func UnmarshalWithOptions(data []byte, v any, opts DecodeOptions) error {
+	if opts.MaxTotalBytes > 0 && int64(len(data)) > opts.MaxTotalBytes {
+		return &LimitError{Limit: "MaxTotalBytes", Max: opts.MaxTotalBytes, Offset: opts.MaxTotalBytes}
+	}
+	d := &decodeState{data: data, opts: opts}
+	return d.unmarshalInto(v)
+}
+
+func (d *decodeState) limitError(off int, limit string, max int64) error {
+	return &LimitError{
+		Limit:  limit,
+		Max:    max,
+		Offset: d.base + int64(off),
+		Path:   d.path.String(),
+	}
+}
+
+// enter records the start of a list or dictionary at off.
+func (d *decodeState) enter(off int) error {
+	d.depth++
+	if d.opts.MaxDepth > 0 && d.depth > d.opts.MaxDepth {
+		return d.limitError(off, "MaxDepth", int64(d.opts.MaxDepth))
+	}
+	return nil
+}
+
+func (d *decodeState) leave() {
+	d.depth--
+}
@@ -0,0 +1,119 @@
+package bencoding
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOptions_Limits(t *testing.T) {
+	limits := DecodeOptions{
+		MaxDepth:        3,
+		MaxStringLength: 8,
+		MaxTotalBytes:   64,
+		MaxDictEntries:  2,
+	}
+	tests := []struct {
+		name      string
+		raw       string
+		wantLimit string
+		wantPath  string
+	}{
+		{name: "within limits", raw: "d1:alli1eee1:b8:abcdefghe"},
+		{name: "too deep", raw: "d1:allli1eeeee", wantLimit: "MaxDepth", wantPath: "a[0][0]"},
+		{name: "long string", raw: "l9:abcdefghie", wantLimit: "MaxStringLength", wantPath: "[0]"},
+		{name: "huge declared string", raw: "99999999999:", wantLimit: "MaxStringLength"},
+		{name: "too many entries", raw: "d1:ai1e1:bi2e1:ci3ee", wantLimit: "MaxDictEntries"},
+		{name: "too long", raw: "l" + strings.Repeat("i1e", 30) + "e", wantLimit: "MaxTotalBytes"},
+		{name: "endless integer", raw: "i" + strings.Repeat("1", 100) + "e", wantLimit: "MaxTotalBytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(t *testing.T, err error, checkPath bool) {
+				if tt.wantLimit == "" {
+					assert.NoError(t, err)
+					return
+				}
+				var limitErr *LimitError
+				if assert.ErrorAs(t, err, &limitErr) {
+					assert.Equal(t, tt.wantLimit, limitErr.Limit)
+					if checkPath {
+						assert.Equal(t, tt.wantPath, limitErr.Path)
+					}
+				}
+			}
+			t.Run("Unmarshal", func(t *testing.T) {
+				var got any
+				check(t, UnmarshalWithOptions([]byte(tt.raw), &got, limits), tt.wantLimit != "MaxTotalBytes")
+			})
+			t.Run("Decode", func(t *testing.T) {
+				dec := NewDecoder(strings.NewReader(tt.raw))
+				dec.SetOptions(limits)
+				var got any
+				// values are scanned before being decoded, so some limits are hit before the path is known
+				check(t, dec.Decode(&got), false)
+			})
+			t.Run("Token", func(t *testing.T) {
+				dec := NewDecoder(strings.NewReader(tt.raw))
+				dec.SetOptions(limits)
+				var err error
+				for err == nil {
+					_, err = dec.Token()
+				}
+				if err == io.EOF {
+					err = nil
+				}
+				check(t, err, tt.wantLimit != "MaxTotalBytes")
+			})
+		})
+	}
+}
+
+// endlessReader produces an infinitely long bencoded string.
+type endlessReader struct{ started bool }
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		return copy(p, "999999:"), nil
+	}
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestDecodeOptions_BoundedRead(t *testing.T) {
+	r := &countingReader{r: &endlessReader{}}
+	dec := NewDecoder(r)
+	dec.SetOptions(DecodeOptions{MaxTotalBytes: 1 << 10})
+	var got any
+	var limitErr *LimitError
+	assert.ErrorAs(t, dec.Decode(&got), &limitErr)
+	assert.Less(t, r.n, 4<<10)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestDecodeOptions_Stream(t *testing.T) {
+	// limits apply to each top-level value, not to the stream as a whole
+	dec := NewDecoder(strings.NewReader(strings.Repeat("li1ei2ee", 10)))
+	dec.SetOptions(DecodeOptions{MaxTotalBytes: 8, MaxDepth: 1})
+	for dec.More() {
+		var got []int
+		if !assert.NoError(t, dec.Decode(&got)) {
+			return
+		}
+		assert.Equal(t, []int{1, 2}, got)
+	}
+}
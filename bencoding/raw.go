@@ -0,0 +1,36 @@
+package bencoding
+
+import "errors"
+
+// Marshaler is the interface implemented by types that can marshal themselves into valid bencoding.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal a bencoded description of themselves. The
+// input is a single complete bencoded value. UnmarshalBencode must copy the data if it wishes to retain it after
+// returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+// RawMessage is a raw encoded bencoded value. It can be used to delay decoding part of a message, or to keep the
+// exact original bytes of a value such as a metainfo's info dictionary, whose hash identifies the torrent.
+type RawMessage []byte
+
+// MarshalBencode returns m as the bencoding of m.
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if m == nil {
+		return nil, errors.New("bencoding: cannot marshal empty RawMessage")
+	}
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return errors.New("bencoding: UnmarshalBencode on nil pointer")
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
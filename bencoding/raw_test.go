@@ -0,0 +1,40 @@
+package bencoding
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRawMessage(t *testing.T) {
+	// keys are deliberately unsorted and the integer is non-canonical, so re-encoding would change the bytes
+	raw := []byte("d4:infod4:name1:x5:filesld6:lengthi1e4:pathl1:aeee5:extrai-0ee8:announce3:urle")
+	var got struct {
+		Announce string     `bencode:"announce"`
+		Info     RawMessage `bencode:"info"`
+	}
+	assert.NoError(t, Unmarshal(raw, &got))
+	assert.Equal(t, "url", got.Announce)
+	assert.Equal(t, "d4:name1:x5:filesld6:lengthi1e4:pathl1:aeee5:extrai-0ee", string(got.Info))
+
+	out, err := Marshal(got)
+	assert.NoError(t, err)
+	assert.Equal(t, "d8:announce3:url4:infod4:name1:x5:filesld6:lengthi1e4:pathl1:aeee5:extrai-0eee", string(out))
+
+	var list []RawMessage
+	assert.NoError(t, Unmarshal([]byte("li1e3:abclee"), &list))
+	assert.Equal(t, []RawMessage{RawMessage("i1e"), RawMessage("3:abc"), RawMessage("le")}, list)
+}
+
+func TestRawMessage_Invalid(t *testing.T) {
+	_, err := Marshal(map[string]RawMessage{"a": RawMessage("i1")})
+	assert.Error(t, err)
+
+	_, err = Marshal(struct{ A RawMessage }{})
+	assert.Error(t, err)
+
+	out, err := Marshal(struct {
+		A RawMessage `bencode:"a,omitempty"`
+	}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "de", string(out))
+}
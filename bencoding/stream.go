@@ -0,0 +1,433 @@
+package bencoding
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// A Decoder reads and decodes bencoded values from an input stream.
+//
+// Values can be read whole with Decode or piece by piece with Token, and the two can be mixed. The Decoder buffers
+// input and may read past the end of the last value requested; Buffered returns whatever has not been consumed.
+type Decoder struct {
+	r       io.Reader
+	buf     []byte
+	scanp   int   // start of unconsumed data in buf
+	scanned int64 // bytes discarded from the front of buf
+	err     error // sticky read error
+	opts    DecodeOptions
+
+	valueStart int64 // input offset of the current top-level value
+
+	tokenStack []tokenFrame // one entry per open container
+}
+
+type tokenFrame struct {
+	kind  byte   // 'l' for lists, 'k' for dictionaries expecting a key and 'v' for dictionaries expecting a value
+	index int    // number of list elements or dictionary entries consumed
+	key   string // most recent dictionary key
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Strict causes the Decoder to reject input that is not canonically bencoded. See UnmarshalStrict.
+func (d *Decoder) Strict() {
+	d.opts.Strict = true
+}
+
+// SetOptions configures the Decoder, replacing any previous options. Limits are enforced before the data they guard
+// is read, so a Decoder can safely consume untrusted streams.
+func (d *Decoder) SetOptions(opts DecodeOptions) {
+	d.opts = opts
+}
+
+// A Token holds a value of one of these types:
+//
+//	Delim, for the start and end of lists and dictionaries
+//	int64, for integers
+//	*big.Int, for integers that do not fit in an int64
+//	string, for dictionary keys and byte strings ([]byte if DecodeOptions.UseBytes is set)
+type Token any
+
+// A Delim marks the start or end of a list or dictionary.
+type Delim byte
+
+const (
+	ListStart Delim = 'l'
+	DictStart Delim = 'd'
+	End       Delim = 'e'
+)
+
+func (d Delim) String() string {
+	return string(d)
+}
+
+// Decode reads the next bencoded value from its input and stores it in the value pointed to by v.
+//
+// See the documentation for Unmarshal for details about the conversion of bencoding into a Go value.
+func (d *Decoder) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	if err := d.tokenPrepareForValue(); err != nil {
+		return err
+	}
+	d.tokenPrepareForToken()
+
+	n, err := d.readValue()
+	if err != nil {
+		return err
+	}
+	ds := d.decodeState(n)
+	d.scanp += n
+	d.tokenValueEnd()
+	return ds.value(rv)
+}
+
+// decodeState returns a decodeState for the next n buffered bytes.
+func (d *Decoder) decodeState(n int) *decodeState {
+	return &decodeState{
+		data:  d.buf[d.scanp : d.scanp+n],
+		base:  d.InputOffset(),
+		path:  d.tokenPath(),
+		depth: len(d.tokenStack),
+		opts:  d.opts,
+	}
+}
+
+func (d *Decoder) limitError(rel int, limit string, max int64) error {
+	return &LimitError{
+		Limit:  limit,
+		Max:    max,
+		Offset: d.InputOffset() + int64(rel),
+		Path:   d.tokenPath().String(),
+	}
+}
+
+// checkTotal enforces MaxTotalBytes for a value extending rel bytes into the unconsumed input.
+func (d *Decoder) checkTotal(rel int) error {
+	if max := d.opts.MaxTotalBytes; max > 0 && d.InputOffset()+int64(rel)-d.valueStart > max {
+		return d.limitError(int(d.valueStart+max-d.InputOffset()), "MaxTotalBytes", max)
+	}
+	return nil
+}
+
+func (d *Decoder) syntaxError(rel int, format string, args ...any) error {
+	return &SyntaxError{
+		msg:    fmt.Sprintf(format, args...),
+		Offset: d.InputOffset() + int64(rel),
+		Path:   d.tokenPath().String(),
+	}
+}
+
+// tokenPath returns the path to the next value.
+func (d *Decoder) tokenPath() fieldPath {
+	var p fieldPath
+	for _, f := range d.tokenStack {
+		switch f.kind {
+		case 'l':
+			p.pushIndex(f.index)
+		case 'v':
+			p.pushKey(f.key)
+		}
+	}
+	return p
+}
+
+// Token returns the next bencoded token in the input stream. At the end of the input stream, Token returns nil,
+// io.EOF.
+//
+// Token guarantees that the delimiters it returns are properly nested and matched, and that dictionary keys are byte
+// strings. If Token encounters unexpected input, it returns an error.
+//
+// Dictionary keys are returned as strings unless DecodeOptions.UseBytes is set.
+func (d *Decoder) Token() (Token, error) {
+	d.tokenPrepareForToken()
+	if err := d.need(0); err != nil {
+		if err == io.EOF && len(d.tokenStack) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	b := d.buf[d.scanp]
+	var top *tokenFrame
+	if len(d.tokenStack) > 0 {
+		top = &d.tokenStack[len(d.tokenStack)-1]
+	}
+	switch {
+	case b == 'e':
+		if top == nil || top.kind == 'v' {
+			return nil, d.syntaxError(0, "unexpected end of container")
+		}
+		d.scanp++
+		d.tokenStack = d.tokenStack[:len(d.tokenStack)-1]
+		d.tokenValueEnd()
+		return End, nil
+	case top != nil && top.kind == 'k':
+		if b < '0' || b > '9' {
+			return nil, d.syntaxError(0, "dict: key must be a string")
+		}
+		if max := d.opts.MaxDictEntries; max > 0 && top.index >= max {
+			return nil, d.limitError(0, "MaxDictEntries", int64(max))
+		}
+		tok, n, err := d.peekScalar()
+		if err != nil {
+			return nil, err
+		}
+		var key string
+		switch k := tok.(type) {
+		case string:
+			key = k
+		case []byte:
+			key = string(k)
+		}
+		if d.opts.Strict && top.index > 0 && key <= top.key {
+			if key == top.key {
+				return nil, d.syntaxError(0, "dict: duplicate key %q", key)
+			}
+			return nil, d.syntaxError(0, "dict: key %q is not sorted after %q", key, top.key)
+		}
+		d.scanp += n
+		top.kind = 'v'
+		top.key = key
+		return tok, nil
+	case b == 'l' || b == 'd':
+		if max := d.opts.MaxDepth; max > 0 && len(d.tokenStack) >= max {
+			return nil, d.limitError(0, "MaxDepth", int64(max))
+		}
+		d.scanp++
+		if b == 'l' {
+			d.tokenStack = append(d.tokenStack, tokenFrame{kind: 'l'})
+			return ListStart, nil
+		}
+		d.tokenStack = append(d.tokenStack, tokenFrame{kind: 'k'})
+		return DictStart, nil
+	default:
+		tok, n, err := d.peekScalar()
+		if err != nil {
+			return nil, err
+		}
+		d.scanp += n
+		d.tokenValueEnd()
+		return tok, nil
+	}
+}
+
+// More reports whether there is another element in the current list or dictionary, or another value in the stream
+// if no container is open.
+func (d *Decoder) More() bool {
+	if err := d.need(0); err != nil {
+		return false
+	}
+	return d.buf[d.scanp] != 'e'
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer. The reader is valid until the next call
+// to Decode or Token.
+func (d *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(d.buf[d.scanp:])
+}
+
+// InputOffset returns the offset in the input stream of the next byte to be decoded.
+func (d *Decoder) InputOffset() int64 {
+	return d.scanned + int64(d.scanp)
+}
+
+func (d *Decoder) tokenPrepareForValue() error {
+	if len(d.tokenStack) > 0 && d.tokenStack[len(d.tokenStack)-1].kind == 'k' {
+		return d.syntaxError(0, "dict: cannot decode a value in place of a key")
+	}
+	return nil
+}
+
+// tokenPrepareForToken records the start of a new top-level value if no container is open.
+func (d *Decoder) tokenPrepareForToken() {
+	if len(d.tokenStack) == 0 {
+		d.valueStart = d.InputOffset()
+	}
+}
+
+// tokenValueEnd records that a complete value was consumed from the current container.
+func (d *Decoder) tokenValueEnd() {
+	if len(d.tokenStack) == 0 {
+		return
+	}
+	top := &d.tokenStack[len(d.tokenStack)-1]
+	top.index++
+	if top.kind == 'v' {
+		top.kind = 'k'
+	}
+}
+
+// peekScalar decodes the integer or string token at the front of the buffer, returning it and its length.
+func (d *Decoder) peekScalar() (Token, int, error) {
+	n, err := d.tokenEnd(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	var tok any
+	ds := d.decodeState(n)
+	if err := ds.value(reflect.ValueOf(&tok).Elem()); err != nil {
+		return nil, 0, err
+	}
+	return tok, n, nil
+}
+
+// readValue buffers the whole value at the front of the buffer and returns its length.
+func (d *Decoder) readValue() (int, error) {
+	if err := d.need(0); err != nil {
+		return 0, err
+	}
+	depth := 0
+	rel := 0
+	for {
+		end, err := d.tokenEnd(rel)
+		if err != nil {
+			return 0, err
+		}
+		switch d.buf[d.scanp+rel] {
+		case 'l', 'd':
+			depth++
+		case 'e':
+			depth--
+		}
+		if depth < 0 {
+			return 0, d.syntaxError(rel, "unexpected end of container")
+		}
+		rel = end
+		if depth == 0 {
+			return rel, nil
+		}
+	}
+}
+
+// tokenEnd buffers the token starting rel bytes into the unconsumed input and returns the offset just past it.
+func (d *Decoder) tokenEnd(rel int) (end int, err error) {
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+	if err := d.need(rel); err != nil {
+		return 0, err
+	}
+	switch b := d.buf[d.scanp+rel]; {
+	case b == 'l' || b == 'd' || b == 'e':
+		return rel + 1, d.checkTotal(rel + 1)
+	case b == 'i':
+		e, err := d.find('e', rel+1)
+		if err != nil {
+			return 0, err
+		}
+		return e + 1, d.checkTotal(e + 1)
+	case b >= '0' && b <= '9':
+		colon, err := d.find(':', rel+1)
+		if err != nil {
+			return 0, err
+		}
+		if err := d.checkTotal(colon + 1); err != nil {
+			return 0, err
+		}
+		strLen, err := strconv.Atoi(string(d.buf[d.scanp+rel : d.scanp+colon]))
+		if err != nil {
+			return 0, d.syntaxError(rel, "string: invalid length: %v", err)
+		}
+		if max := d.opts.MaxStringLength; max > 0 && strLen > max {
+			return 0, d.limitError(rel, "MaxStringLength", int64(max))
+		}
+		if strLen > math.MaxInt-d.scanp-colon-1 {
+			// no input could be this long, and the offset of its end would overflow
+			return 0, d.syntaxError(rel, "string: invalid length: %d is too large", strLen)
+		}
+		end = colon + 1 + strLen
+		if err := d.checkTotal(end); err != nil {
+			return 0, err
+		}
+		if strLen > 0 {
+			if err := d.need(end - 1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	default:
+		return 0, d.syntaxError(rel, "invalid character %q looking for beginning of value", b)
+	}
+}
+
+// find buffers input until it finds c at or after rel bytes into the unconsumed input, returning its offset.
+func (d *Decoder) find(c byte, rel int) (int, error) {
+	for {
+		if i := bytes.IndexByte(d.buf[d.scanp+rel:], c); i >= 0 {
+			return rel + i, nil
+		}
+		rel = len(d.buf) - d.scanp
+		if err := d.checkTotal(rel + 1); err != nil {
+			return 0, err
+		}
+		if err := d.need(rel); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// need reads until the byte rel bytes into the unconsumed input is buffered.
+func (d *Decoder) need(rel int) error {
+	for d.scanp+rel >= len(d.buf) {
+		if d.err != nil {
+			return d.err
+		}
+		d.refill()
+	}
+	return nil
+}
+
+func (d *Decoder) refill() {
+	if d.scanp > 0 {
+		d.scanned += int64(d.scanp)
+		n := copy(d.buf, d.buf[d.scanp:])
+		d.buf = d.buf[:n]
+		d.scanp = 0
+	}
+
+	const minRead = 512
+	if cap(d.buf)-len(d.buf) < minRead {
+		newBuf := make([]byte, len(d.buf), 2*cap(d.buf)+minRead)
+		copy(newBuf, d.buf)
+		d.buf = newBuf
+	}
+
+	n, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+	d.buf = d.buf[:len(d.buf)+n]
+	d.err = err
+}
+
+// An Encoder writes bencoded values to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v to the stream.
+//
+// See the documentation for Marshal for details about the conversion of Go values to bencoding.
+func (e *Encoder) Encode(v any) error {
+	b, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
@@ -0,0 +1,192 @@
+package bencoding
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoder_Token(t *testing.T) {
+	raw := "d8:intervali1800e5:peersld2:ip9:127.0.0.14:porti6881eeee"
+	for name, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(raw),
+		"one byte": iotest.OneByteReader(strings.NewReader(raw)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dec := NewDecoder(r)
+			var got []Token
+			for {
+				tok, err := dec.Token()
+				if err == io.EOF {
+					break
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				got = append(got, tok)
+			}
+			assert.Equal(t, []Token{
+				DictStart, "interval", int64(1800), "peers", ListStart,
+				DictStart, "ip", "127.0.0.1", "port", int64(6881), End,
+				End, End,
+			}, got)
+			assert.Equal(t, int64(len(raw)), dec.InputOffset())
+		})
+	}
+}
+
+func TestDecoder_TokenErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{name: "non-string key", raw: "di1ei2ee"},
+		{name: "missing value", raw: "d1:ae"},
+		{name: "unmatched end", raw: "i1ee"},
+		{name: "unterminated list", raw: "li1e", want: io.ErrUnexpectedEOF},
+		{name: "truncated string", raw: "5:abc", want: io.ErrUnexpectedEOF},
+		{name: "huge string length", raw: "9223372036854775807:abc"},
+		{name: "huge string length in list", raw: "l9223372036854775807:abce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoder(strings.NewReader(tt.raw))
+			var err error
+			for err == nil {
+				_, err = dec.Token()
+			}
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+			} else {
+				assert.NotErrorIs(t, err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeHugeStringLength(t *testing.T) {
+	for _, opts := range []DecodeOptions{{}, {MaxTotalBytes: 64}} {
+		var got any
+		dec := NewDecoder(strings.NewReader("d1:a9223372036854775807:abce"))
+		dec.SetOptions(opts)
+		err := dec.Decode(&got)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestDecoder_DecodeConcatenated(t *testing.T) {
+	// ut_metadata data messages are a bencoded dictionary immediately followed by the raw piece.
+	raw := "d8:msg_typei1e5:piecei0e10:total_sizei8ee" + "rawbytes"
+	r := iotest.OneByteReader(strings.NewReader(raw))
+	dec := NewDecoder(r)
+
+	var msg struct {
+		MsgType   int `bencode:"msg_type"`
+		Piece     int `bencode:"piece"`
+		TotalSize int `bencode:"total_size"`
+	}
+	assert.NoError(t, dec.Decode(&msg))
+	assert.Equal(t, 1, msg.MsgType)
+	assert.Equal(t, 8, msg.TotalSize)
+	assert.Equal(t, int64(len(raw)-8), dec.InputOffset())
+
+	rest, err := io.ReadAll(io.MultiReader(dec.Buffered(), r))
+	assert.NoError(t, err)
+	assert.Equal(t, "rawbytes", string(rest))
+}
+
+func TestDecoder_DecodeStream(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1e3:abcli2eed1:xi3ee"))
+	var got []any
+	for dec.More() {
+		var v any
+		if !assert.NoError(t, dec.Decode(&v)) {
+			return
+		}
+		got = append(got, v)
+	}
+	assert.Equal(t, []any{int64(1), "abc", []any{int64(2)}, map[string]any{"x": int64(3)}}, got)
+
+	var v any
+	assert.ErrorIs(t, dec.Decode(&v), io.EOF)
+}
+
+func TestDecoder_TokenThenDecode(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d5:filesld6:lengthi1eed6:lengthi2eee4:name1:xe"))
+	var lengths []int
+	for _, want := range []Token{DictStart, "files", ListStart} {
+		tok, err := dec.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, want, tok)
+	}
+	for dec.More() {
+		var f testFile
+		assert.NoError(t, dec.Decode(&f))
+		lengths = append(lengths, f.Length)
+	}
+	assert.Equal(t, []int{1, 2}, lengths)
+
+	for _, want := range []Token{End, "name", "x", End} {
+		tok, err := dec.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, want, tok)
+	}
+	_, err := dec.Token()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	assert.NoError(t, enc.Encode(map[string]any{"a": 1}))
+	assert.NoError(t, enc.Encode("xyz"))
+	assert.Error(t, enc.Encode(1.5))
+	assert.Equal(t, "d1:ai1ee3:xyz", buf.String())
+}
+
+func TestDecoder_Strict(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d1:ai1e1:ai2ee"))
+	dec.Strict()
+	var err error
+	for err == nil {
+		_, err = dec.Token()
+	}
+	var syntaxErr *SyntaxError
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, int64(7), syntaxErr.Offset)
+	}
+
+	dec = NewDecoder(strings.NewReader("d5:filesld6:lengthi-0eeee"))
+	dec.Strict()
+	for _, want := range []Token{DictStart, "files", ListStart} {
+		tok, err := dec.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, want, tok)
+	}
+	var f testFile
+	err = dec.Decode(&f)
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, int64(19), syntaxErr.Offset)
+		assert.Equal(t, "files[0].length", syntaxErr.Path)
+	}
+}
+
+func TestDecoder_TokenUseBytes(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d2:id3:\x00\xff\x01e"))
+	dec.SetOptions(DecodeOptions{UseBytes: true})
+	var got []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		got = append(got, tok)
+	}
+	assert.Equal(t, []Token{DictStart, []byte("id"), []byte{0, 0xff, 1}, End}, got)
+}
@@ -1,196 +1,542 @@
 package bencoding
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
-	"io"
+	"math/big"
+	"reflect"
 	"strconv"
 )
 
-type ByteReader interface {
-	ReadByte() (byte, error)
-	UnreadByte() error
-	Read([]byte) (int, error)
+// Unmarshal parses the bencoded data and stores the result in the value pointed to by v.
+//
+// Unmarshal uses the inverse of the mappings used by Marshal, allocating maps, slices and pointers as necessary.
+// Dictionary keys without a matching struct field are ignored. Integers of any size can be decoded into a big.Int. To
+// unmarshal into an interface value, Unmarshal stores one of:
+//
+//	int64, for integers
+//	*big.Int, for integers that do not fit in an int64
+//	string, for byte strings ([]byte if DecodeOptions.UseBytes is set)
+//	[]any, for lists
+//	map[string]any, for dictionaries
+func Unmarshal(data []byte, v any) error {
+	d := &decodeState{data: data}
+	return d.unmarshalInto(v)
 }
 
-func Unmarshal(raw ByteReader) (result any, err error) {
-	b, err := raw.ReadByte()
-	if err != nil {
-		return nil, err
+// UnmarshalStrict is like Unmarshal but only accepts the canonical bencoding of a value, as produced by Marshal.
+//
+// BEP-3 allows exactly one encoding of every value: integers have no leading zeros and no negative zero, string
+// lengths have no leading zeros and dictionary keys appear once each, sorted as raw byte strings. Input breaking any
+// of these rules is rejected with a *SyntaxError.
+func UnmarshalStrict(data []byte, v any) error {
+	return UnmarshalWithOptions(data, v, DecodeOptions{Strict: true})
+}
+
+func (d *decodeState) unmarshalInto(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
 	}
-	if err := raw.UnreadByte(); err != nil {
-		return nil, err
+	if err := d.value(rv); err != nil {
+		return err
 	}
-	defer func() {
-		if err != nil {
-			result = nil
-		}
-	}()
-	switch b {
-	case 'i':
-		return UnmarshalInt(raw)
-	case 'l':
-		return UnmarshalList(raw)
-	case 'd':
-		return UnmarshalDict(raw)
-	case '0':
-		fallthrough
-	case '1':
-		fallthrough
-	case '2':
-		fallthrough
-	case '3':
-		fallthrough
-	case '4':
-		fallthrough
-	case '5':
-		fallthrough
-	case '6':
-		fallthrough
-	case '7':
-		fallthrough
-	case '8':
-		fallthrough
-	case '9':
-		return UnmarshalString(raw)
-	default:
-		return nil, errors.New("unrecognized prefix")
+	if d.off != len(d.data) {
+		return d.syntaxError(d.off, "unexpected data after top-level value")
 	}
+	return nil
 }
 
-func UnmarshalInt(raw ByteReader) (int, error) {
-	b, err := raw.ReadByte()
-	if err != nil {
-		return 0, err
+// An InvalidUnmarshalError describes an invalid argument passed to Unmarshal. The argument must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "bencoding: Unmarshal(nil)"
 	}
-	if b != 'i' {
-		return 0, errors.New("int: encoding must begin with 'i'")
+	if e.Type.Kind() != reflect.Pointer {
+		return "bencoding: Unmarshal(non-pointer " + e.Type.String() + ")"
 	}
+	return "bencoding: Unmarshal(nil " + e.Type.String() + ")"
+}
 
-	intBytes := make([]byte, 0)
-	b, err = raw.ReadByte()
-	if err != nil {
-		return 0, err
+// An UnmarshalTypeError describes a bencoded value that was not appropriate for the Go value it was decoded into.
+type UnmarshalTypeError struct {
+	Value  string       // description of the bencoded value: "integer", "string", "list" or "dictionary"
+	Type   reflect.Type // type of the Go value it could not be assigned to
+	Offset int64        // offset of the value in the input
+	Field  string       // the full path to the value, like "info.files[3].length"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return "bencoding: cannot unmarshal " + e.Value + " into field " + e.Field + " of type " + e.Type.String()
 	}
-	if (b < '0' || b > '9') && b != '-' {
-		return 0, errors.New("int encoding must start with a number or '-'")
+	return "bencoding: cannot unmarshal " + e.Value + " into value of type " + e.Type.String()
+}
+
+// A SyntaxError describes malformed bencoding, or non-canonical bencoding when decoding strictly.
+type SyntaxError struct {
+	msg    string
+	Offset int64  // offset of the error in the input
+	Path   string // the full path to the value being decoded, like "info.files[3].path"
+}
+
+func (e *SyntaxError) Error() string {
+	msg := "bencoding: " + e.msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+	if e.Path != "" {
+		msg += " (" + e.Path + ")"
 	}
-	if b == '0' {
-		b, err = raw.ReadByte()
-		if err != nil {
-			return 0, err
-		}
-		if b != 'e' {
-			return 0, errors.New("int: encoding cannot have leading zeros")
-		}
-		return 0, nil
+	return msg
+}
+
+type decodeState struct {
+	data  []byte
+	off   int
+	base  int64 // offset of data in the overall input
+	path  fieldPath
+	depth int // number of open lists and dictionaries
+	opts  DecodeOptions
+}
+
+func (d *decodeState) typeError(what string, t reflect.Type, start int) error {
+	return &UnmarshalTypeError{
+		Value:  what,
+		Type:   t,
+		Offset: d.base + int64(start),
+		Field:  d.path.String(),
 	}
-	intBytes = append(intBytes, b)
+}
 
-	for b, err = raw.ReadByte(); err == nil; b, err = raw.ReadByte() {
-		if b < '0' || b > '9' {
-			if b != 'e' {
-				return 0, errors.New("int: encoding must end with 'e'")
-			}
-			break
-		}
-		intBytes = append(intBytes, b)
+func (d *decodeState) syntaxError(off int, format string, args ...any) error {
+	return &SyntaxError{
+		msg:    fmt.Sprintf(format, args...),
+		Offset: d.base + int64(off),
+		Path:   d.path.String(),
 	}
-	if err != nil {
-		return 0, err
+}
+
+// rebaseError places an error returned while decoding data[start:] independently (by an Unmarshaler) in the
+// context of the overall input.
+func (d *decodeState) rebaseError(err error, start int) error {
+	switch e := err.(type) {
+	case *SyntaxError:
+		e.Offset += d.base + int64(start)
+		e.Path = joinPath(d.path.String(), e.Path)
+	case *UnmarshalTypeError:
+		e.Offset += d.base + int64(start)
+		e.Field = joinPath(d.path.String(), e.Field)
+	}
+	return err
+}
+
+func (d *decodeState) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, d.syntaxError(d.off, "unexpected end of input")
 	}
-	return strconv.Atoi(string(intBytes))
+	return d.data[d.off], nil
 }
 
-func UnmarshalList(raw ByteReader) ([]any, error) {
-	b, err := raw.ReadByte()
+// value decodes the next value into v. An invalid v discards the value after validating it.
+func (d *decodeState) value(v reflect.Value) error {
+	if v.IsValid() {
+		var u Unmarshaler
+		if u, v = indirect(v); u != nil {
+			start := d.off
+			if err := d.value(reflect.Value{}); err != nil {
+				return err
+			}
+			return d.rebaseError(u.UnmarshalBencode(d.data[start:d.off]), start)
+		}
+	}
+
+	b, err := d.peek()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if b != 'l' {
-		return nil, errors.New("list: encoding must begin with 'l'")
+	switch {
+	case b == 'i':
+		return d.int(v)
+	case b == 'l':
+		return d.list(v)
+	case b == 'd':
+		return d.dict(v)
+	case b >= '0' && b <= '9':
+		return d.string(v)
+	default:
+		return d.syntaxError(d.off, "invalid character %q looking for beginning of value", b)
 	}
+}
 
-	var l []any
+// indirect walks down v, allocating pointers as needed, until it reaches a non-pointer or a value implementing
+// Unmarshaler. Empty interfaces holding nothing are returned as-is so the caller can decide what to store in them.
+func indirect(v reflect.Value) (Unmarshaler, reflect.Value) {
+	// A named value may implement Unmarshaler through its pointer, as RawMessage does.
+	if v.Kind() != reflect.Pointer && v.Type().Name() != "" && v.CanAddr() {
+		v = v.Addr()
+	}
 	for {
-		b, err = raw.ReadByte()
-		if err != nil {
-			return nil, err
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			e := v.Elem()
+			if e.Kind() == reflect.Pointer && !e.IsNil() {
+				v = e
+				continue
+			}
 		}
-		if b == 'e' {
-			return l, nil
+		if v.Kind() != reflect.Pointer {
+			return nil, v
 		}
-		if err = raw.UnreadByte(); err != nil {
-			return nil, err
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
 		}
-		elem, err := Unmarshal(raw)
-		if err != nil {
-			return nil, err
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(Unmarshaler); ok {
+				return u, reflect.Value{}
+			}
 		}
-		l = append(l, elem)
+		v = v.Elem()
 	}
 }
 
-func UnmarshalDict(raw ByteReader) (map[string]any, error) {
-	b, err := raw.ReadByte()
+func isEmptyInterface(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0
+}
+
+// literalInt scans an integer, returning its digits (and sign).
+func (d *decodeState) literalInt() ([]byte, error) {
+	if d.off >= len(d.data) || d.data[d.off] != 'i' {
+		return nil, d.syntaxError(d.off, "int: encoding must begin with 'i'")
+	}
+	d.off++
+	start := d.off
+	if d.off >= len(d.data) {
+		return nil, d.syntaxError(d.off, "int: unexpected end of input")
+	}
+	b := d.data[d.off]
+	if (b < '0' || b > '9') && b != '-' {
+		return nil, d.syntaxError(d.off, "int: encoding must start with a number or '-'")
+	}
+	if b == '0' && d.off+1 < len(d.data) && d.data[d.off+1] != 'e' {
+		return nil, d.syntaxError(d.off, "int: encoding cannot have leading zeros")
+	}
+	for d.off++; d.off < len(d.data); d.off++ {
+		b = d.data[d.off]
+		if b == 'e' {
+			lit := d.data[start:d.off]
+			if len(lit) == 1 && lit[0] == '-' {
+				return nil, d.syntaxError(start, "int: missing digits")
+			}
+			if d.opts.Strict && lit[0] == '-' && lit[1] == '0' {
+				return nil, d.syntaxError(start, "int: non-canonical negative number %q", lit)
+			}
+			d.off++
+			return lit, nil
+		}
+		if b < '0' || b > '9' {
+			return nil, d.syntaxError(d.off, "int: encoding must end with 'e'")
+		}
+	}
+	return nil, d.syntaxError(d.off, "int: unexpected end of input")
+}
+
+func (d *decodeState) int(v reflect.Value) error {
+	start := d.off
+	lit, err := d.literalInt()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if !v.IsValid() {
+		return nil
 	}
-	if b != 'd' {
-		return nil, errors.New("dict: encoding must begin with 'd'")
+	if v.Type() == bigIntType {
+		v.Addr().Interface().(*big.Int).SetString(string(lit), 10)
+		return nil
 	}
 
-	dict := make(map[string]any)
-	for {
-		b, err = raw.ReadByte()
-		if err != nil {
-			return nil, err
+	switch v.Kind() {
+	case reflect.Interface:
+		if !isEmptyInterface(v) {
+			return d.typeError("integer", v.Type(), start)
 		}
-		if b == 'e' {
-			return dict, nil
+		if n, err := strconv.ParseInt(string(lit), 10, 64); err == nil {
+			v.Set(reflect.ValueOf(n))
+		} else {
+			n, _ := new(big.Int).SetString(string(lit), 10)
+			v.Set(reflect.ValueOf(n))
 		}
-		if err := raw.UnreadByte(); err != nil {
-			return nil, fmt.Errorf("dict: unable to parse key: %w", err)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(lit), 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			return d.typeError("integer "+string(lit), v.Type(), start)
 		}
-
-		key, err := UnmarshalString(raw)
-		if err != nil {
-			return nil, err
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(lit), 10, 64)
+		if err != nil || v.OverflowUint(n) {
+			return d.typeError("integer "+string(lit), v.Type(), start)
 		}
-		value, err := Unmarshal(raw)
-		if err != nil {
-			return nil, fmt.Errorf("dict: unable to parse value for %q: %w", key, err)
+		v.SetUint(n)
+	case reflect.Bool:
+		switch string(lit) {
+		case "0":
+			v.SetBool(false)
+		case "1":
+			v.SetBool(true)
+		default:
+			return d.typeError("integer "+string(lit), v.Type(), start)
 		}
-		dict[key] = value
+	default:
+		return d.typeError("integer", v.Type(), start)
 	}
+	return nil
 }
 
-func UnmarshalString(raw ByteReader) (string, error) {
-	lenBytes := make([]byte, 0)
-	var b byte
-	var err error
-	for b, err = raw.ReadByte(); err == nil; b, err = raw.ReadByte() {
+// literalString scans a byte string, returning its contents. The returned slice aliases the input.
+func (d *decodeState) literalString() ([]byte, error) {
+	start := d.off
+	for ; d.off < len(d.data); d.off++ {
+		b := d.data[d.off]
 		if b == ':' {
 			break
 		}
 		if b < '0' || b > '9' {
-			return "", errors.New("string: encoding must start with length")
+			return nil, d.syntaxError(d.off, "string: encoding must start with length")
 		}
-		lenBytes = append(lenBytes, b)
 	}
-	if err != nil {
-		return "", err
+	if d.off >= len(d.data) {
+		return nil, d.syntaxError(d.off, "string: unexpected end of input")
+	}
+	lenBytes := d.data[start:d.off]
+	if d.opts.Strict && len(lenBytes) > 1 && lenBytes[0] == '0' {
+		return nil, d.syntaxError(start, "string: length cannot have leading zeros")
 	}
-
 	strLen, err := strconv.Atoi(string(lenBytes))
 	if err != nil {
-		return "", err
+		return nil, d.syntaxError(start, "string: invalid length: %v", err)
+	}
+	if d.opts.MaxStringLength > 0 && strLen > d.opts.MaxStringLength {
+		return nil, d.limitError(start, "MaxStringLength", int64(d.opts.MaxStringLength))
 	}
+	d.off++ // ':'
+	if remaining := len(d.data) - d.off; strLen > remaining {
+		return nil, d.syntaxError(start, "string: unable to read declared string length (wanted %d bytes, read %d bytes)", strLen, remaining)
+	}
+	s := d.data[d.off : d.off+strLen]
+	d.off += strLen
+	return s, nil
+}
 
-	strBytes := make([]byte, strLen, strLen)
-	bytesRead, err := io.ReadFull(raw, strBytes)
-	if err != nil {
-		return "", fmt.Errorf("string: unable to read declared string length (wanted %d bytes, read %d bytes): %w", strLen, bytesRead, err)
+func (d *decodeState) string(v reflect.Value) error {
+	start := d.off
+	s, err := d.literalString()
+	if err != nil || !v.IsValid() {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if !isEmptyInterface(v) {
+			return d.typeError("string", v.Type(), start)
+		}
+		if d.opts.UseBytes {
+			v.Set(reflect.ValueOf(append([]byte{}, s...)))
+		} else {
+			v.Set(reflect.ValueOf(string(s)))
+		}
+	case reflect.String:
+		v.SetString(string(s))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return d.typeError("string", v.Type(), start)
+		}
+		v.SetBytes(append([]byte{}, s...))
+	case reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 || v.Len() != len(s) {
+			return d.typeError("string", v.Type(), start)
+		}
+		reflect.Copy(v, reflect.ValueOf(s))
+	default:
+		return d.typeError("string", v.Type(), start)
+	}
+	return nil
+}
+
+func (d *decodeState) list(v reflect.Value) error {
+	start := d.off
+	if err := d.enter(start); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'l'
+
+	if v.IsValid() {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !isEmptyInterface(v) {
+				return d.typeError("list", v.Type(), start)
+			}
+			l := make([]any, 0)
+			rl := reflect.ValueOf(&l).Elem()
+			if err := d.listElems(rl); err != nil {
+				return err
+			}
+			v.Set(rl)
+			return nil
+		case reflect.Slice:
+			if v.IsNil() {
+				v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			}
+			v.SetLen(0)
+		case reflect.Array:
+		default:
+			return d.typeError("list", v.Type(), start)
+		}
+	}
+	return d.listElems(v)
+}
+
+func (d *decodeState) listElems(v reflect.Value) error {
+	for i := 0; ; i++ {
+		b, err := d.peek()
+		if err != nil {
+			return err
+		}
+		if b == 'e' {
+			d.off++
+			if v.IsValid() && v.Kind() == reflect.Array {
+				zero := reflect.Zero(v.Type().Elem())
+				for ; i < v.Len(); i++ {
+					v.Index(i).Set(zero)
+				}
+			}
+			return nil
+		}
+
+		var elem reflect.Value
+		if v.IsValid() {
+			switch v.Kind() {
+			case reflect.Slice:
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+				elem = v.Index(i)
+			case reflect.Array:
+				if i < v.Len() {
+					elem = v.Index(i)
+				}
+			}
+		}
+		d.path.pushIndex(i)
+		if err := d.value(elem); err != nil {
+			return err
+		}
+		d.path.pop()
+	}
+}
+
+func (d *decodeState) dict(v reflect.Value) error {
+	start := d.off
+	if err := d.enter(start); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'd'
+
+	var fields *structFields
+	if v.IsValid() {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !isEmptyInterface(v) {
+				return d.typeError("dictionary", v.Type(), start)
+			}
+			m := make(map[string]any)
+			rm := reflect.ValueOf(m)
+			if err := d.dictEntries(rm, nil); err != nil {
+				return err
+			}
+			v.Set(rm)
+			return nil
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return d.typeError("dictionary", v.Type(), start)
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+		case reflect.Struct:
+			if v.Type() == bigIntType {
+				return d.typeError("dictionary", v.Type(), start)
+			}
+			fields = cachedFields(v.Type())
+		default:
+			return d.typeError("dictionary", v.Type(), start)
+		}
+	}
+	return d.dictEntries(v, fields)
+}
+
+func (d *decodeState) dictEntries(v reflect.Value, fields *structFields) error {
+	var prevKey []byte
+	for i := 0; ; i++ {
+		b, err := d.peek()
+		if err != nil {
+			return err
+		}
+		if b == 'e' {
+			d.off++
+			return nil
+		}
+
+		keyStart := d.off
+		if d.opts.MaxDictEntries > 0 && i >= d.opts.MaxDictEntries {
+			return d.limitError(keyStart, "MaxDictEntries", int64(d.opts.MaxDictEntries))
+		}
+		key, err := d.literalString()
+		if err != nil {
+			return err
+		}
+		if d.opts.Strict && i > 0 && bytes.Compare(prevKey, key) >= 0 {
+			if bytes.Equal(prevKey, key) {
+				return d.syntaxError(keyStart, "dict: duplicate key %q", key)
+			}
+			return d.syntaxError(keyStart, "dict: key %q is not sorted after %q", key, prevKey)
+		}
+		prevKey = key
+		d.path.pushKey(string(key))
+
+		var elem reflect.Value
+		switch {
+		case !v.IsValid():
+		case v.Kind() == reflect.Map:
+			elem = reflect.New(v.Type().Elem()).Elem()
+		case fields != nil:
+			if f, ok := fields.byName[string(key)]; ok {
+				elem = fieldForDecode(v, f.index)
+			}
+		}
+		if err := d.value(elem); err != nil {
+			return err
+		}
+		if v.IsValid() && v.Kind() == reflect.Map {
+			v.SetMapIndex(reflect.ValueOf(string(key)).Convert(v.Type().Key()), elem)
+		}
+		d.path.pop()
+	}
+}
+
+// fieldForDecode returns the (possibly embedded) field of v, allocating embedded pointers along the way.
+func fieldForDecode(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
 	}
-	return string(strBytes), err
+	return v
 }
@@ -1,9 +1,9 @@
 package bencoding
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"testing"
 )
 
@@ -19,19 +19,19 @@ func TestUnmarshal(t *testing.T) {
 		{
 			name:    "positive number",
 			raw:     []byte("i234e"),
-			want:    234,
+			want:    int64(234),
 			wantErr: assert.NoError,
 		},
 		{
 			name:    "negative number",
 			raw:     []byte("i-10e"),
-			want:    -10,
+			want:    int64(-10),
 			wantErr: assert.NoError,
 		},
 		{
 			name:    "zero",
 			raw:     []byte("i0e"),
-			want:    0,
+			want:    int64(0),
 			wantErr: assert.NoError,
 		},
 		{
@@ -68,7 +68,7 @@ func TestUnmarshal(t *testing.T) {
 		{
 			name:    "[]int",
 			raw:     []byte("li1ei2ei0ei-10ee"),
-			want:    []any{1, 2, 0, -10},
+			want:    []any{int64(1), int64(2), int64(0), int64(-10)},
 			wantErr: assert.NoError,
 		},
 		{
@@ -80,7 +80,7 @@ func TestUnmarshal(t *testing.T) {
 		{
 			name:    "map[string]int",
 			raw:     []byte("d5:helloi3e6:world!i-343ee"),
-			want:    map[string]any{"hello": 3, "world!": -343},
+			want:    map[string]any{"hello": int64(3), "world!": int64(-343)},
 			wantErr: assert.NoError,
 		},
 		{
@@ -92,7 +92,8 @@ func TestUnmarshal(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := Unmarshal(bytes.NewReader(tt.raw))
+			var got any
+			err := Unmarshal(tt.raw, &got)
 			if !tt.wantErr(t, err, fmt.Sprintf("Unmarshal(%s)", tt.raw)) {
 				return
 			}
@@ -100,3 +101,141 @@ func TestUnmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshal_Struct(t *testing.T) {
+	raw := []byte("d8:announce14:http://tracker7:comment2:hi4:infod5:filesld6:lengthi3e4:pathl1:a1:beee4:name3:dir6:pieces3:\x00\x01\x027:privatei1e7:unknownli1eeee")
+	var got testMetainfo
+	assert.NoError(t, Unmarshal(raw, &got))
+
+	comment := "hi"
+	assert.Equal(t, testMetainfo{
+		Announce: "http://tracker",
+		Info: &testInfo{
+			Name:    "dir",
+			Pieces:  []byte{0, 1, 2},
+			Files:   []testFile{{Length: 3, Path: []string{"a", "b"}}},
+			Private: true,
+		},
+		Comment: &comment,
+	}, got)
+
+	roundTrip, err := Marshal(got)
+	assert.NoError(t, err)
+	var again testMetainfo
+	assert.NoError(t, Unmarshal(roundTrip, &again))
+	assert.Equal(t, got, again)
+}
+
+func TestUnmarshal_Containers(t *testing.T) {
+	var m map[string][]int
+	assert.NoError(t, Unmarshal([]byte("d1:ali1ei2ee1:blee"), &m))
+	assert.Equal(t, map[string][]int{"a": {1, 2}, "b": {}}, m)
+
+	var arr [20]byte
+	assert.NoError(t, Unmarshal([]byte("20:abcdefghijklmnopqrst"), &arr))
+	assert.Equal(t, "abcdefghijklmnopqrst", string(arr[:]))
+
+	var small uint8
+	assert.Error(t, Unmarshal([]byte("i256e"), &small))
+}
+
+func TestUnmarshal_TypeError(t *testing.T) {
+	var got testMetainfo
+	err := Unmarshal([]byte("d4:infod5:filesld6:lengthi1e4:pathl1:aeed6:length1:x4:pathleeeee"), &got)
+	var typeErr *UnmarshalTypeError
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, "info.files[1].length", typeErr.Field)
+		assert.Equal(t, "string", typeErr.Value)
+		assert.EqualError(t, err, "bencoding: cannot unmarshal string into field info.files[1].length of type int")
+	}
+}
+
+func TestUnmarshal_InvalidArgument(t *testing.T) {
+	var got testMetainfo
+	assert.Error(t, Unmarshal([]byte("de"), got))
+	assert.Error(t, Unmarshal([]byte("de"), nil))
+	assert.Error(t, Unmarshal([]byte("dee"), &got), "trailing data")
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		wantOffset int64
+		wantPath   string
+	}{
+		{name: "negative zero", raw: "i-0e", wantOffset: 1},
+		{name: "negative leading zero", raw: "li1ei-03ee", wantOffset: 5, wantPath: "[1]"},
+		{name: "leading zero", raw: "i03e", wantOffset: 1},
+		{name: "string length leading zero", raw: "d1:a03:abce", wantOffset: 4, wantPath: "a"},
+		{name: "unsorted keys", raw: "d1:bi1e1:ai2ee", wantOffset: 7},
+		{name: "duplicate keys", raw: "d1:ai1e1:ai2ee", wantOffset: 7},
+		{name: "unsorted nested keys", raw: "d4:infod5:filesld4:path0:6:lengthi1eeeee", wantOffset: 25, wantPath: "info.files[0]"},
+		{name: "trailing data", raw: "i1ei2e", wantOffset: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got any
+			err := UnmarshalStrict([]byte(tt.raw), &got)
+			var syntaxErr *SyntaxError
+			if assert.ErrorAs(t, err, &syntaxErr) {
+				assert.Equal(t, tt.wantOffset, syntaxErr.Offset)
+				assert.Equal(t, tt.wantPath, syntaxErr.Path)
+			}
+		})
+	}
+
+	var got any
+	assert.NoError(t, UnmarshalStrict([]byte("d1:ai-3e1:bli0e0:ee"), &got))
+	assert.NoError(t, Unmarshal([]byte("d1:bi-0e1:a03:abce"), &got), "lenient mode accepts non-canonical input")
+}
+
+func TestUnmarshal_SyntaxError(t *testing.T) {
+	var got struct {
+		Info RawMessage `bencode:"info"`
+	}
+	err := Unmarshal([]byte("d4:infod5:filesld6:lengthi1e4:pathl1:ai2x3eeeeee"), &got)
+	var syntaxErr *SyntaxError
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, int64(40), syntaxErr.Offset)
+		assert.Equal(t, "info.files[0].path[1]", syntaxErr.Path)
+		assert.EqualError(t, err, "bencoding: int: encoding must end with 'e' at offset 40 (info.files[0].path[1])")
+	}
+}
+
+func TestUnmarshal_LargeIntegers(t *testing.T) {
+	var sizes struct {
+		Length int64    `bencode:"length"`
+		Offset uint64   `bencode:"offset"`
+		Huge   *big.Int `bencode:"huge"`
+		Any    any      `bencode:"any"`
+	}
+	raw := []byte("d3:anyi-100000000000000000000000e4:hugei340282366920938463463374607431768211456e6:lengthi8796093022208e6:offseti18446744073709551615ee")
+	assert.NoError(t, Unmarshal(raw, &sizes))
+	assert.Equal(t, int64(8796093022208), sizes.Length)
+	assert.Equal(t, uint64(18446744073709551615), sizes.Offset)
+	assert.Equal(t, "340282366920938463463374607431768211456", sizes.Huge.String())
+	assert.Equal(t, "-100000000000000000000000", sizes.Any.(*big.Int).String())
+
+	out, err := Marshal(sizes)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, out)
+
+	var small int32
+	var typeErr *UnmarshalTypeError
+	assert.ErrorAs(t, Unmarshal([]byte("i8796093022208e"), &small), &typeErr)
+	var n big.Int
+	assert.ErrorAs(t, Unmarshal([]byte("de"), &n), &typeErr)
+}
+
+func TestUnmarshal_UseBytes(t *testing.T) {
+	// compact peer lists and node IDs are arbitrary binary strings
+	raw := []byte("d5:peers6:\x7f\x00\x00\x01\x1a\xe1e")
+	var got any
+	assert.NoError(t, UnmarshalWithOptions(raw, &got, DecodeOptions{UseBytes: true}))
+	assert.Equal(t, map[string]any{"peers": []byte{127, 0, 0, 1, 0x1a, 0xe1}}, got)
+
+	out, err := Marshal(got)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, out)
+}
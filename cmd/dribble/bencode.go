@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Byte strings that are not valid UTF-8 are represented in JSON as single-entry objects keyed by their encoding, e.g.
+// {"$hex": "00ff"}, and dictionary keys as their encoding prefixed with the same name, e.g. "$hex:00ff", so that they
+// survive a round trip through "bencode decode" and "bencode encode".
+const (
+	hexKey    = "$hex"
+	base64Key = "$base64"
+)
+
+func runBencode(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: bencode decode|encode [flags] [file]")
+	}
+	switch args[0] {
+	case "decode":
+		return runBencodeDecode(args[1:])
+	case "encode":
+		return runBencodeEncode(args[1:])
+	default:
+		return fmt.Errorf("unknown bencode command %q", args[0])
+	}
+}
+
+func runBencodeDecode(args []string) error {
+	fs := flag.NewFlagSet("bencode decode", flag.ExitOnError)
+	binary := fs.String("binary", "hex", "encoding for binary strings: hex or base64")
+	path := fs.String("path", "", "only print the value at this path, like info.files[0].path")
+	strict := fs.Bool("strict", false, "reject non-canonical bencoding")
+	_ = fs.Parse(args)
+	if *binary != "hex" && *binary != "base64" {
+		return fmt.Errorf("unknown binary encoding %q", *binary)
+	}
+
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var v any
+	if err := bencoding.UnmarshalWithOptions(data, &v, bencoding.DecodeOptions{Strict: *strict, UseBytes: true}); err != nil {
+		return err
+	}
+	if v, err = selectPath(v, *path); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(toJSON(v, *binary))
+}
+
+func runBencodeEncode(args []string) error {
+	fs := flag.NewFlagSet("bencode encode", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	b, err := fromJSON(v)
+	if err != nil {
+		return err
+	}
+	out, err := bencoding.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// readInput reads the named file, or standard input if the name is empty or "-".
+func readInput(name string) ([]byte, error) {
+	if name == "" || name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// selectPath returns the value found by following a path like "info.files[0].path" into a decoded value.
+func selectPath(v any, path string) (any, error) {
+	for walked := ""; path != ""; {
+		var elem string
+		if strings.HasPrefix(path, "[") {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("malformed path: missing ']' after %q", walked)
+			}
+			elem, path = path[:end+1], path[end+1:]
+			idx, err := strconv.Atoi(elem[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("malformed path: bad index %s", elem)
+			}
+			l, ok := v.([]any)
+			if !ok || idx < 0 || idx >= len(l) {
+				return nil, fmt.Errorf("%s%s: no such list element", walked, elem)
+			}
+			v = l[idx]
+		} else {
+			path = strings.TrimPrefix(path, ".")
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			elem, path = path[:end], path[end:]
+			d, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: not a dictionary", walked)
+			}
+			if v, ok = d[elem]; !ok {
+				return nil, fmt.Errorf("%s: no such key %q", walked, elem)
+			}
+			if walked != "" {
+				elem = "." + elem
+			}
+		}
+		walked += elem
+	}
+	return v, nil
+}
+
+// toJSON converts a decoded bencoded value into one that encoding/json renders faithfully.
+func toJSON(v any, binary string) any {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		if binary == "base64" {
+			return map[string]string{base64Key: base64.StdEncoding.EncodeToString(v)}
+		}
+		return map[string]string{hexKey: hex.EncodeToString(v)}
+	case []any:
+		l := make([]any, len(v))
+		for i, elem := range v {
+			l[i] = toJSON(elem, binary)
+		}
+		return l
+	case map[string]any:
+		d := make(map[string]any, len(v))
+		for k, elem := range v {
+			d[keyToJSON(k, binary)] = toJSON(elem, binary)
+		}
+		return d
+	default:
+		// int64 and *big.Int are already rendered as JSON numbers
+		return v
+	}
+}
+
+// fromJSON converts a value decoded by encoding/json (with UseNumber) into one that bencoding can marshal.
+func fromJSON(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		n, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("bencoding only supports integers, got %s", v)
+		}
+		return n, nil
+	case []any:
+		l := make([]any, len(v))
+		for i, elem := range v {
+			var err error
+			if l[i], err = fromJSON(elem); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	case map[string]any:
+		if len(v) == 1 {
+			if s, ok := v[hexKey].(string); ok {
+				return hex.DecodeString(s)
+			}
+			if s, ok := v[base64Key].(string); ok {
+				return base64.StdEncoding.DecodeString(s)
+			}
+		}
+		d := make(map[string]any, len(v))
+		for k, elem := range v {
+			key, err := keyFromJSON(k)
+			if err != nil {
+				return nil, err
+			}
+			if d[key], err = fromJSON(elem); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	case bool:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("cannot bencode JSON value %v", v)
+	}
+}
+
+// keyToJSON converts a dictionary key into a JSON object key. Keys that are not valid UTF-8, or that could be mistaken
+// for an encoded key, are encoded.
+func keyToJSON(k, binary string) string {
+	if utf8.ValidString(k) && !strings.HasPrefix(k, hexKey+":") && !strings.HasPrefix(k, base64Key+":") {
+		return k
+	}
+	if binary == "base64" {
+		return base64Key + ":" + base64.StdEncoding.EncodeToString([]byte(k))
+	}
+	return hexKey + ":" + hex.EncodeToString([]byte(k))
+}
+
+// keyFromJSON reverses keyToJSON.
+func keyFromJSON(k string) (string, error) {
+	var b []byte
+	var err error
+	switch {
+	case strings.HasPrefix(k, hexKey+":"):
+		b, err = hex.DecodeString(strings.TrimPrefix(k, hexKey+":"))
+	case strings.HasPrefix(k, base64Key+":"):
+		b, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(k, base64Key+":"))
+	default:
+		return k, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid dictionary key %q: %w", k, err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stringList is a flag that may be repeated to collect several values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func runCreate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	var announce stringList
+	fs.Var(&announce, "announce", "tracker announce url (repeat for backup trackers)")
+	comment := fs.String("comment", "", "free-form comment")
+	createdBy := fs.String("created-by", "bytedribble", "name of the program creating the torrent")
+	private := fs.Bool("private", false, "mark the torrent as private")
+	pieceSize := fs.Int64("piece-size", 0, "piece size in bytes (chosen automatically if 0)")
+	noDate := fs.Bool("no-date", false, "omit the creation date")
+	output := fs.String("o", "", "output file (defaults to <name>.torrent)")
+	_ = fs.Parse(args)
+
+	path := fs.Arg(0)
+	if path == "" {
+		return errors.New("missing path to file or directory")
+	}
+	b := bytedribble.MetainfoBuilder{
+		Path:           path,
+		AnnounceURLs:   announce,
+		Comment:        *comment,
+		CreatedBy:      *createdBy,
+		Private:        *private,
+		PieceSizeBytes: *pieceSize,
+	}
+	if !*noDate {
+		b.CreationDate = time.Now()
+	}
+	data, err := b.Build(ctx)
+	if err != nil {
+		return err
+	}
+	meta, err := bytedribble.ParseMetainfo(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		*output = filepath.Base(abs) + ".torrent"
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Println("Wrote:", *output)
+	fmt.Println("Infohash (hex):", hex.EncodeToString(meta.InfoHash()))
+	fmt.Println("Piece size (bytes):", meta.PieceSizeBytes)
+	return nil
+}
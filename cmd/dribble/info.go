@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// torrentInfo is the summary of a torrent or magnet link printed by "info". Fields that a magnet link does not
+// describe are left empty.
+type torrentInfo struct {
+	Name         string     `json:"name"`
+	InfoHash     string     `json:"infoHash,omitempty"`
+	InfoHashV2   string     `json:"infoHashV2,omitempty"`
+	MetaVersion  int        `json:"metaVersion,omitempty"`
+	Private      bool       `json:"private"`
+	PieceSize    int64      `json:"pieceSize,omitempty"`
+	Pieces       int        `json:"pieces,omitempty"`
+	TotalSize    int64      `json:"totalSize,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	CreatedBy    string     `json:"createdBy,omitempty"`
+	CreationDate *time.Time `json:"creationDate,omitempty"`
+	Encoding     string     `json:"encoding,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	Trackers     [][]string `json:"trackers,omitempty"`
+	WebSeeds     []string   `json:"webSeeds,omitempty"`
+	HTTPSeeds    []string   `json:"httpSeeds,omitempty"`
+	Nodes        []string   `json:"nodes,omitempty"`
+	Peers        []string   `json:"peers,omitempty"`
+	Files        []fileInfo `json:"files,omitempty"`
+}
+
+type fileInfo struct {
+	Path []string `json:"path"`
+	Size int64    `json:"size"`
+	Attr string   `json:"attr,omitempty"`
+}
+
+func runInfo(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON instead of a human-readable summary")
+	_ = fs.Parse(args)
+
+	arg := fs.Arg(0)
+	if arg == "" {
+		return errors.New("missing path to torrent file or magnet link")
+	}
+	var info torrentInfo
+	if strings.HasPrefix(strings.ToLower(arg), "magnet:") {
+		m, err := bytedribble.ParseMagnet(arg)
+		if err != nil {
+			return err
+		}
+		info = magnetInfo(m)
+	} else {
+		f, err := os.Open(arg)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		meta, err := bytedribble.ParseMetainfo(f)
+		if err != nil {
+			return err
+		}
+		info = metainfoInfo(meta)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(info)
+	}
+	printInfo(os.Stdout, info)
+	return nil
+}
+
+func metainfoInfo(meta bytedribble.Metainfo) torrentInfo {
+	info := torrentInfo{
+		Name:        meta.Name,
+		InfoHash:    hex.EncodeToString(meta.InfoHash()),
+		InfoHashV2:  hex.EncodeToString(meta.InfoHashV2()),
+		MetaVersion: meta.MetaVersion,
+		Private:     meta.Private,
+		PieceSize:   meta.PieceSizeBytes,
+		Pieces:      meta.NumPieces(),
+		TotalSize:   meta.TotalSizeBytes,
+		Comment:     meta.Comment,
+		CreatedBy:   meta.CreatedBy,
+		Encoding:    meta.Encoding,
+		Source:      meta.Source,
+		Nodes:       meta.Nodes,
+	}
+	if !meta.CreationDate.IsZero() {
+		date := meta.CreationDate.UTC()
+		info.CreationDate = &date
+	}
+	for _, tier := range meta.AnnounceTiers() {
+		var urls []string
+		for _, u := range tier {
+			urls = append(urls, u.String())
+		}
+		info.Trackers = append(info.Trackers, urls)
+	}
+	for _, u := range meta.WebSeeds {
+		info.WebSeeds = append(info.WebSeeds, u.String())
+	}
+	for _, u := range meta.HTTPSeeds {
+		info.HTTPSeeds = append(info.HTTPSeeds, u.String())
+	}
+	for _, f := range meta.Files {
+		info.Files = append(info.Files, fileInfo{Path: f.Path, Size: f.SizeBytes, Attr: f.Attr})
+	}
+	if meta.Files == nil {
+		info.Files = []fileInfo{{Path: []string{meta.Name}, Size: meta.TotalSizeBytes}}
+	}
+	return info
+}
+
+func magnetInfo(m bytedribble.Magnet) torrentInfo {
+	info := torrentInfo{
+		Name:       m.DisplayName,
+		InfoHashV2: hex.EncodeToString(m.InfoHashV2),
+		TotalSize:  m.ExactLength,
+		WebSeeds:   m.WebSeeds,
+		Peers:      m.Peers,
+	}
+	// a v2 magnet link without btih has no v1 infohash, only the truncated v2 one
+	if m.HasInfoHashV1() {
+		info.InfoHash = hex.EncodeToString(m.InfoHash[:])
+	}
+	if len(m.Trackers) > 0 {
+		info.Trackers = [][]string{m.Trackers}
+	}
+	return info
+}
+
+// printInfo prints a human-readable summary of a torrent, omitting anything it does not describe.
+func printInfo(w io.Writer, info torrentInfo) {
+	field := func(label string, value any) {
+		fmt.Fprintf(w, "%-14s %v\n", label+":", value)
+	}
+	list := func(label string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s:\n", label)
+		for _, v := range values {
+			fmt.Fprintf(w, "  %s\n", v)
+		}
+	}
+
+	field("Name", info.Name)
+	if info.InfoHash != "" {
+		field("Info hash", info.InfoHash)
+	}
+	if info.InfoHashV2 != "" {
+		field("Info hash v2", info.InfoHashV2)
+	}
+	if info.MetaVersion != 0 {
+		field("Meta version", info.MetaVersion)
+	}
+	if info.Pieces != 0 {
+		field("Private", info.Private)
+		field("Pieces", fmt.Sprintf("%d × %s", info.Pieces, formatSize(info.PieceSize)))
+	}
+	if info.TotalSize != 0 {
+		field("Total size", fmt.Sprintf("%s (%d bytes)", formatSize(info.TotalSize), info.TotalSize))
+	}
+	for _, f := range []struct{ label, value string }{
+		{"Comment", info.Comment},
+		{"Created by", info.CreatedBy},
+		{"Encoding", info.Encoding},
+		{"Source", info.Source},
+	} {
+		if f.value != "" {
+			field(f.label, f.value)
+		}
+	}
+	if info.CreationDate != nil {
+		field("Created", info.CreationDate.Format(time.RFC3339))
+	}
+
+	if len(info.Trackers) > 0 {
+		fmt.Fprintln(w, "Trackers:")
+		for i, tier := range info.Trackers {
+			for _, u := range tier {
+				fmt.Fprintf(w, "  tier %d: %s\n", i, u)
+			}
+		}
+	}
+	list("Web seeds", info.WebSeeds)
+	list("HTTP seeds", info.HTTPSeeds)
+	list("DHT nodes", info.Nodes)
+	list("Peers", info.Peers)
+
+	if len(info.Files) > 0 {
+		fmt.Fprintln(w, "Files:")
+		printFileTree(w, info.Name, info.Files)
+	}
+}
+
+// printFileTree prints files as an indented tree, introducing each directory before the first file within it.
+func printFileTree(w io.Writer, name string, files []fileInfo) {
+	if len(files) == 1 && len(files[0].Path) == 1 && files[0].Path[0] == name {
+		fmt.Fprintf(w, "  %s (%s)\n", name, formatSize(files[0].Size))
+		return
+	}
+	fmt.Fprintf(w, "  %s/\n", name)
+	var dir []string
+	for _, f := range files {
+		if len(f.Path) == 0 {
+			// not produced by ParseMetainfo, but nothing to place in the tree either
+			continue
+		}
+		parent := f.Path[:len(f.Path)-1]
+		common := 0
+		for common < len(dir) && common < len(parent) && dir[common] == parent[common] {
+			common++
+		}
+		for i := common; i < len(parent); i++ {
+			fmt.Fprintf(w, "  %s%s/\n", strings.Repeat("  ", i+1), parent[i])
+		}
+		dir = parent
+
+		var attrs string
+		if f.Attr != "" {
+			attrs = " [" + f.Attr + "]"
+		}
+		fmt.Fprintf(w, "  %s%s (%s)%s\n", strings.Repeat("  ", len(parent)+1), f.Path[len(f.Path)-1], formatSize(f.Size), attrs)
+	}
+}
+
+// formatSize formats a number of bytes using binary units, like "1.5 MiB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"os"
+)
+
+func runMagnet(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("magnet", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	metainfoPath := fs.Arg(0)
+	if metainfoPath == "" {
+		return errors.New("missing path to torrent file")
+	}
+	metainfoFile, err := os.Open(metainfoPath)
+	if err != nil {
+		return err
+	}
+	defer metainfoFile.Close()
+
+	meta, err := bytedribble.ParseMetainfo(metainfoFile)
+	if err != nil {
+		return err
+	}
+	fmt.Println(meta.Magnet())
+	return nil
+}
@@ -3,36 +3,83 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/bunsenmcdubbs/bytedribble"
 	"log"
 	"os"
 	"os/signal"
+	"sort"
 )
 
+// commands maps subcommand names to their implementations. Each receives the arguments following its name.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"bencode":  runBencode,
+	"create":   runCreate,
+	"download": runDownload,
+	"info":     runInfo,
+	"magnet":   runMagnet,
+	"scrape":   runScrape,
+	"tracker":  runTracker,
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s <command> [arguments]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", name)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\n%s <path to torrent> is short for %s download <path to torrent>\n", os.Args[0], os.Args[0])
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	metainfoPath := flag.Arg(0)
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	args := flag.Args()[1:]
+	if !ok {
+		run, args = runDownload, flag.Args()
+	}
+	if err := run(ctx, args); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func runDownload(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	metainfoPath := fs.Arg(0)
 	if metainfoPath == "" {
-		log.Fatalln("missing path to torrent file")
+		return errors.New("missing path to torrent file")
 	}
 
 	metainfoFile, err := os.Open(metainfoPath)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	defer metainfoFile.Close()
 
 	meta, err := bytedribble.ParseMetainfo(metainfoFile)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
-	fmt.Println("Tracker URL:", meta.TrackerURL.String())
+	if meta.TrackerURL != nil {
+		fmt.Println("Tracker URL:", meta.TrackerURL.String())
+	}
 	fmt.Println("Infohash (hex):", hex.EncodeToString(meta.InfoHash()))
 	fmt.Println("Piece size (bytes):", meta.PieceSizeBytes)
 
@@ -40,6 +87,7 @@ func main() {
 		PeerID: bytedribble.PeerIDFromString("01234567890123456789"),
 		IP:     nil,
 		Port:   9424,
-	})
+	}, nil)
 	d.Start(ctx)
+	return nil
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"os"
+)
+
+func runScrape(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	metainfoPath := fs.Arg(0)
+	if metainfoPath == "" {
+		return errors.New("missing path to torrent file")
+	}
+	metainfoFile, err := os.Open(metainfoPath)
+	if err != nil {
+		return err
+	}
+	defer metainfoFile.Close()
+
+	meta, err := bytedribble.ParseMetainfo(metainfoFile)
+	if err != nil {
+		return err
+	}
+	// hybrid torrents are shared by two swarms, which the tracker counts separately
+	infoHashes := meta.SwarmInfoHashes()
+	tc := bytedribble.NewTrackerClient(nil, meta, bytedribble.PeerInfo{}, bytedribble.FakeMetrics{})
+	results, err := tc.Scrape(ctx, infoHashes...)
+	if err != nil {
+		return err
+	}
+	for i, r := range results {
+		fmt.Printf("%s: %d seeders, %d leechers, %d downloads\n", hex.EncodeToString(infoHashes[i]), r.Complete, r.Incomplete, r.Downloaded)
+	}
+	return nil
+}
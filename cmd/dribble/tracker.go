@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble/tracker"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+func runTracker(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tracker", flag.ExitOnError)
+	listen := fs.String("listen", ":6969", "address to serve the HTTP tracker on, or empty to disable it")
+	udpListen := fs.String("udp", ":6969", "address to serve the UDP tracker on, or empty to disable it")
+	interval := fs.Duration("interval", tracker.DefaultInterval, "how often peers are asked to announce")
+	storePath := fs.String("private", "", "run a private tracker with the users and torrents in this JSON file")
+	addUser := fs.String("add-user", "", "add a user with a new passkey to the private tracker, print the passkey and exit")
+	allow := fs.String("allow", "", "allow the torrent with this hex infohash on the private tracker and exit")
+	_ = fs.Parse(args)
+
+	var store *tracker.FileStore
+	if *storePath != "" {
+		var err error
+		if store, err = tracker.NewFileStore(*storePath); err != nil {
+			return err
+		}
+	}
+	if *addUser != "" || *allow != "" {
+		if store == nil {
+			return errors.New("-add-user and -allow require -private")
+		}
+		return editStore(store, *addUser, *allow)
+	}
+	if *listen == "" && *udpListen == "" {
+		return errors.New("nothing to serve")
+	}
+	if *interval < time.Second {
+		return errors.New("-interval must be at least 1s")
+	}
+
+	// both front ends share the same swarms
+	swarms := tracker.NewSwarms(*interval)
+	handler, udpServer := tracker.NewHandler(swarms), tracker.NewUDPServer(swarms)
+	if store != nil {
+		private := tracker.NewPrivate(swarms, store)
+		handler, udpServer = tracker.NewPrivateHandler(private), tracker.NewPrivateUDPServer(private)
+		defer func() {
+			// save the traffic reported since the last save
+			if err := store.Flush(); err != nil {
+				log.Printf("failed to save %s: %v", *storePath, err)
+			}
+		}()
+		log.Printf("private tracker: announce URLs are of the form http://%s/<passkey>/announce", *listen)
+	}
+	errs := make(chan error, 2)
+	servers := 0
+	if *listen != "" {
+		srv := &http.Server{Addr: *listen, Handler: handler}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Shutdown(context.Background())
+		}()
+		log.Printf("serving HTTP tracker at http://%s/announce", *listen)
+		servers++
+		go func() {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+				return
+			}
+			errs <- nil
+		}()
+	}
+	if *udpListen != "" {
+		conn, err := net.ListenPacket("udp", *udpListen)
+		if err != nil {
+			return err
+		}
+		go func() {
+			<-ctx.Done()
+			_ = conn.Close()
+		}()
+		log.Printf("serving UDP tracker at udp://%s/announce", *udpListen)
+		servers++
+		go func() {
+			if err := udpServer.Serve(conn); !errors.Is(err, net.ErrClosed) {
+				errs <- err
+				return
+			}
+			errs <- nil
+		}()
+	}
+
+	for i := 0; i < servers; i++ {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// editStore adds a user or allows a torrent on a private tracker.
+func editStore(store *tracker.FileStore, user, infoHash string) error {
+	if user != "" {
+		passkey := tracker.NewPasskey()
+		if err := store.AddUser(tracker.User{Name: user, Passkey: passkey}); err != nil {
+			return err
+		}
+		fmt.Println(passkey)
+	}
+	if infoHash != "" {
+		h, err := hex.DecodeString(infoHash)
+		if err != nil {
+			return fmt.Errorf("invalid infohash: %w", err)
+		}
+		if err := store.Allow(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,41 +7,100 @@ import (
 	"golang.org/x/sync/errgroup"
 	"log"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 )
 
+// maxSeedFailures is the number of consecutive pieces a web or HTTP seed may fail to provide before it is abandoned.
+const maxSeedFailures = 3
+
+// seed is a server that provides whole pieces, such as a WebSeed or HTTPSeed.
+type seed interface {
+	Download(ctx context.Context, p *Piece) error
+	String() string
+}
+
 type Downloader struct {
-	tc     *TrackerClient
-	target Metainfo
-	self   PeerInfo
+	swarms  []*TrackerClient // one per swarm sharing the torrent, see Metainfo.SwarmInfoHashes
+	sources *PeerSources     // other sources of peers for the primary swarm
+	seeds   []seed
+	target  Metainfo
+	self    PeerInfo
 }
 
-func NewDownloader(target Metainfo, self PeerInfo) *Downloader {
+// NewDownloader returns a downloader for target. Its trackers are reached through registry, or DefaultTrackerRegistry
+// if registry is nil.
+func NewDownloader(target Metainfo, self PeerInfo, registry *TrackerRegistry) *Downloader {
 	d := &Downloader{
-		tc:     NewTrackerClient(http.DefaultClient, target, self, FakeMetrics{TotalSize: target.TotalSizeBytes}),
-		target: target,
-		self:   self,
+		sources: NewPeerSources(target),
+		target:  target,
+		self:    self,
+	}
+	for _, infoHash := range target.SwarmInfoHashes() {
+		tc := NewTrackerClient(registry, target, self, FakeMetrics{TotalSize: target.TotalSizeBytes})
+		tc.infoHash = infoHash
+		d.swarms = append(d.swarms, tc)
+	}
+	for _, u := range target.WebSeeds {
+		// FTP web seeds are not supported
+		if u.Scheme == "http" || u.Scheme == "https" {
+			d.seeds = append(d.seeds, NewWebSeed(http.DefaultClient, u, target))
+		}
+	}
+	for _, u := range target.HTTPSeeds {
+		d.seeds = append(d.seeds, NewHTTPSeed(http.DefaultClient, u, target))
 	}
 	return d
 }
 
+// AddPeerSource adds a source of peers besides the torrent's trackers. It returns ErrPrivateTorrent if the torrent is
+// private, unless src is a TrackerPeerSource using only the torrent's own trackers.
+func (d *Downloader) AddPeerSource(src PeerSource) error {
+	return d.sources.Add(src)
+}
+
+// swarmPeer is a peer along with the infohash of the swarm it was found in.
+type swarmPeer struct {
+	PeerInfo
+	infoHash []byte
+}
+
 func (d *Downloader) Start(ctx context.Context) {
-	go func() {
-		for {
-			err := d.tc.Run(ctx)
-			if errors.Is(err, context.Canceled) {
-				return
+	outDir := time.Now().Format(time.RFC3339)
+	storage, err := NewFileStorage(outDir, d.target)
+	if err != nil {
+		log.Println("Refusing to download torrent:", err)
+		return
+	}
+
+	var peers []swarmPeer
+	for _, tc := range d.swarms {
+		tc := tc
+		go func() {
+			for {
+				err := tc.Run(ctx)
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+				log.Println("Sync tracker error:", err)
 			}
-			log.Println("Sync tracker error:", err)
-		}
-	}()
+		}()
 
-	peers, err := d.tc.RequestNewPeers(ctx) // TODO better peer list API
+		swarm, err := tc.RequestNewPeers(ctx) // TODO better peer list API
+		if err != nil {
+			log.Println("Failed to get new peers")
+			// TODO better error handling
+		}
+		for _, info := range swarm {
+			peers = append(peers, swarmPeer{PeerInfo: info, infoHash: tc.infoHash})
+		}
+	}
+	others, err := d.sources.FindPeers(ctx)
 	if err != nil {
-		log.Println("Failed to get new peers")
-		// TODO better error handling
+		log.Println("Failed to find other peers:", err)
+	}
+	for _, info := range others {
+		peers = append(peers, swarmPeer{PeerInfo: info, infoHash: d.target.InfoHash()})
 	}
 
 	// TODO refactor this into a more legible, resilient, and correct form
@@ -49,17 +108,9 @@ func (d *Downloader) Start(ctx context.Context) {
 	pending := make(map[uint32]*Piece)
 	inProgress := make(map[uint32]*Piece)
 	complete := make(map[uint32]*Piece)
-	for idx, hash := range d.target.Hashes {
-		size := d.target.TotalSizeBytes % d.target.PieceSizeBytes
-		if idx != len(d.target.Hashes)-1 {
-			size = d.target.PieceSizeBytes
-		}
-		pending[uint32(idx)] = &Piece{
-			Index:     uint32(idx),
-			Size:      uint32(size),
-			BlockSize: DefaultBlockLength,
-			Hash:      hash,
-		}
+	numPieces := d.target.NumPieces()
+	for idx := 0; idx < numPieces; idx++ {
+		pending[uint32(idx)] = d.target.newPiece(idx)
 		log.Println("Pending piece", pending[uint32(idx)])
 	}
 
@@ -98,35 +149,73 @@ func (d *Downloader) Start(ctx context.Context) {
 		pending[p.Index] = p
 	}
 
+	// Seeds download pieces alongside the workers until there are none left to start.
+	var seedsGroup errgroup.Group
+	for _, s := range d.seeds {
+		s := s
+		seedsGroup.Go(func() error {
+			for failures := 0; failures < maxSeedFailures; {
+				p := startNextPiece()
+				if p == nil {
+					return nil
+				}
+				err := s.Download(ctx, p)
+				if err == nil {
+					failures = 0
+					completePiece(p)
+					continue
+				}
+				failPiece(p)
+				var retry *RetryAfterError
+				if !errors.As(err, &retry) {
+					log.Printf("seed %s failed: %v", s, err)
+					failures++
+					continue
+				}
+				log.Printf("seed %s is busy, retrying in %s", s, retry.Delay)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-doneC:
+					return nil
+				case <-time.After(retry.Delay):
+				}
+			}
+			return nil
+		})
+	}
+
 	workersGroup, workersCtx := errgroup.WithContext(ctx)
 	workersGroup.SetLimit(2) // TODO configure this limit
 
 	var workersMu sync.Mutex
-	workers := make(map[PeerID]*Worker)
+	workers := make(map[string]*Worker) // keyed by address, since peer ids may not be known until the handshake
 
 	for _, info := range peers {
-		log.Println("Attempting to connect to", info)
+		log.Println("Attempting to connect to", info.PeerInfo)
 		if info.PeerID == d.self.PeerID {
 			continue
 		}
-		func(info PeerInfo) {
+		func(info swarmPeer) {
 			workersGroup.Go(func() (err error) {
+				addr := info.Addr()
+				workersMu.Lock()
+				if _, exists := workers[addr]; exists {
+					workersMu.Unlock()
+					return nil
+				}
 				defer func() {
 					if err != nil {
-						log.Printf("peer %s disconnected: %v", info.PeerID, err)
+						log.Printf("peer %s disconnected: %v", addr, err)
 						err = nil
 						workersMu.Lock()
-						delete(workers, info.PeerID)
+						delete(workers, addr)
 						workersMu.Unlock()
 					}
 				}()
 
-				workersMu.Lock()
-				if _, exists := workers[info.PeerID]; exists {
-					// TODO eat this error
-					return errors.New("already connected")
-				}
-				peer := NewPeer(info, d.self.PeerID, d.target.InfoHash(), len(d.target.Hashes))
+				peer := NewPeer(info.PeerInfo, d.self.PeerID, info.infoHash, numPieces)
+				peer.allowExtended = d.target.AllowsPeerSource(PeerExchangeSource)
 				worker := NewWorker(peer)
 				worker.SetCallback(func(piece *Piece, err error) {
 					if err != nil {
@@ -140,7 +229,7 @@ func (d *Downloader) Start(ctx context.Context) {
 						worker.RequestPiece(next)
 					}
 				})
-				workers[info.PeerID] = worker
+				workers[addr] = worker
 				workersMu.Unlock()
 
 				if err = peer.Initialize(workersCtx); err != nil {
@@ -159,19 +248,16 @@ func (d *Downloader) Start(ctx context.Context) {
 	}
 
 	log.Println("Workers finished! Error:", workersGroup.Wait())
-	log.Println("Notified tracker. Error: ", d.tc.Completed(ctx))
+	_ = seedsGroup.Wait()
+	for _, tc := range d.swarms {
+		log.Println("Notified tracker. Error: ", tc.Completed(ctx))
+	}
 	// Lock forever! We are done now.
 	pieceMu.Lock()
 
-	f, err := os.Create(time.Now().Format(time.RFC3339) + d.target.Name)
-	if err != nil {
-		log.Println("Unable to create file", d.target.Name, err)
-		return
-	}
-	defer f.Close()
-	log.Println("Writing downloaded file to disk", f.Name())
+	log.Println("Writing downloaded files to disk", outDir)
 
-	for idx := range d.target.Hashes {
+	for idx := 0; idx < numPieces; idx++ {
 		p, ok := complete[uint32(idx)]
 		if !ok {
 			log.Println("Missing piece", idx)
@@ -181,10 +267,12 @@ func (d *Downloader) Start(ctx context.Context) {
 			log.Println("So-called 'completed' piece is invalid", p)
 			return
 		}
-		if _, err = f.Write(p.Payload()); err != nil {
+		if err := storage.WritePiece(p); err != nil {
 			log.Println("Failed to write piece to file", p, err)
 			return
 		}
 	}
-
+	if err := storage.Finish(); err != nil {
+		log.Println("Failed to finish writing files", err)
+	}
 }
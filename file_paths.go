@@ -0,0 +1,183 @@
+package bytedribble
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPathComponent is the longest file name, in bytes, allowed by common file systems.
+const maxPathComponent = 255
+
+// FilePaths returns where each of the torrent's files should be stored beneath root: the torrent's own file for a
+// single-file torrent, or one path per entry of Files. The paths always lie within root.
+//
+// Path components that could escape root are rejected. Other dangerous components are sanitised: empty and "."
+// components are dropped, separators, NUL bytes and other control characters are replaced, overlong names are
+// truncated and, on Windows, reserved names and characters are avoided. Files whose sanitised paths collide are
+// renamed by adding a number before their extension.
+func (m Metainfo) FilePaths(root string) ([]string, error) {
+	return m.filePaths(root, runtime.GOOS == "windows")
+}
+
+func (m Metainfo) filePaths(root string, windows bool) ([]string, error) {
+	base, err := m.basePath(root, windows)
+	if err != nil {
+		return nil, err
+	}
+	if m.Files == nil {
+		return []string{base}, nil
+	}
+
+	paths := make([]string, len(m.Files))
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for i, file := range m.Files {
+		elems, err := sanitizePath(file.Path, windows)
+		if err != nil {
+			return nil, err
+		}
+		for j := 1; j < len(elems); j++ {
+			dir := pathKey(elems[:j], windows)
+			if files[dir] {
+				return nil, fmt.Errorf("file path %q conflicts with another file", file.Path)
+			}
+			dirs[dir] = true
+		}
+		last := elems[len(elems)-1]
+		for n := 1; files[pathKey(elems, windows)] || dirs[pathKey(elems, windows)]; n++ {
+			elems[len(elems)-1] = numberedName(last, n)
+		}
+		files[pathKey(elems, windows)] = true
+
+		paths[i] = filepath.Join(append([]string{base}, elems...)...)
+		if rel, err := filepath.Rel(base, paths[i]); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("file path %q escapes the download directory", file.Path)
+		}
+	}
+	return paths, nil
+}
+
+// symlinkTargetPath returns the path beneath root of a symlink's target, which is relative to the torrent's root.
+func (m Metainfo) symlinkTargetPath(root string, target []string) (string, error) {
+	windows := runtime.GOOS == "windows"
+	base, err := m.basePath(root, windows)
+	if err != nil {
+		return "", err
+	}
+	elems, err := sanitizePath(target, windows)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{base}, elems...)...), nil
+}
+
+// basePath returns the path of the torrent's file or directory beneath root.
+func (m Metainfo) basePath(root string, windows bool) (string, error) {
+	name, err := sanitizePathComponent(m.Name, windows)
+	if err != nil || name == "" {
+		return "", fmt.Errorf("invalid torrent name %q", m.Name)
+	}
+	return filepath.Join(root, name), nil
+}
+
+// sanitizePath sanitises each component of path, dropping those that are empty.
+func sanitizePath(path []string, windows bool) ([]string, error) {
+	var elems []string
+	for _, elem := range path {
+		clean, err := sanitizePathComponent(elem, windows)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file path %q: %w", path, err)
+		}
+		if clean != "" {
+			elems = append(elems, clean)
+		}
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("invalid file path %q: empty", path)
+	}
+	return elems, nil
+}
+
+// sanitizePathComponent returns a safe version of a single path component, or "" if it should be dropped.
+func sanitizePathComponent(elem string, windows bool) (string, error) {
+	if elem == ".." {
+		return "", errors.New("parent directory component")
+	}
+	if elem == "" || elem == "." {
+		return "", nil
+	}
+	elem = strings.ToValidUTF8(elem, "_")
+	elem = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f || r == '/' || r == '\\':
+			return '_'
+		case windows && strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, elem)
+	if windows {
+		// Windows ignores trailing dots and spaces, which would turn "..." into ".."
+		if trimmed := strings.TrimRight(elem, ". "); trimmed != elem {
+			elem = trimmed + "_"
+		}
+		if isReservedWindowsName(elem) {
+			elem = "_" + elem
+		}
+	}
+	return truncateName(elem, maxPathComponent), nil
+}
+
+// isReservedWindowsName reports whether Windows treats a file name as a device, whatever its extension.
+func isReservedWindowsName(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	stem = strings.ToUpper(strings.TrimRight(stem, " "))
+	switch stem {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(stem) == 4 && (strings.HasPrefix(stem, "COM") || strings.HasPrefix(stem, "LPT")) {
+		return stem[3] >= '1' && stem[3] <= '9'
+	}
+	return false
+}
+
+// truncateName shortens name to at most max bytes, keeping its extension and whole UTF-8 characters.
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > max/2 {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	n := max - len(ext)
+	for n > 0 && !utf8.RuneStart(stem[n]) {
+		n--
+	}
+	return stem[:n] + ext
+}
+
+// numberedName inserts n before the extension of name, like "file.1.txt".
+func numberedName(name string, n int) string {
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return truncateName(name[:len(name)-len(ext)]+"."+strconv.Itoa(n)+ext, maxPathComponent)
+}
+
+// pathKey identifies a path for detecting collisions. Windows file names are case-insensitive.
+func pathKey(elems []string, windows bool) string {
+	key := strings.Join(elems, "/")
+	if windows {
+		key = strings.ToLower(key)
+	}
+	return key
+}
@@ -0,0 +1,143 @@
+package bytedribble
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetainfo_FilePaths(t *testing.T) {
+	root := filepath.Join("downloads", "torrent")
+	join := func(elems ...string) string {
+		return filepath.Join(append([]string{root, "dir"}, elems...)...)
+	}
+	long := strings.Repeat("é", 200) + ".txt"
+
+	tests := []struct {
+		name    string
+		files   [][]string
+		windows bool
+		want    []string
+	}{
+		{
+			name:  "plain",
+			files: [][]string{{"a"}, {"sub", "b.txt"}},
+			want:  []string{join("a"), join("sub", "b.txt")},
+		},
+		{
+			name:  "empty and dot components",
+			files: [][]string{{"", "a"}, {".", "sub", "", "b"}},
+			want:  []string{join("a"), join("sub", "b")},
+		},
+		{
+			name:  "separators and control characters",
+			files: [][]string{{"/etc/passwd"}, {`..\..\x`}, {"nul\x00byte\n"}},
+			want:  []string{join("_etc_passwd"), join(".._.._x"), join("nul_byte_")},
+		},
+		{
+			name:  "invalid UTF-8",
+			files: [][]string{{"bad\xffname"}},
+			want:  []string{join("bad_name")},
+		},
+		{
+			name:  "overlong name",
+			files: [][]string{{long}},
+			want:  []string{join(strings.Repeat("é", 125) + ".txt")},
+		},
+		{
+			name:  "duplicates after sanitisation",
+			files: [][]string{{"a", "b.txt"}, {"a", "", "b.txt"}, {"a", "b.txt"}},
+			want:  []string{join("a", "b.txt"), join("a", "b.1.txt"), join("a", "b.2.txt")},
+		},
+		{
+			name:  "file named like a directory",
+			files: [][]string{{"a", "b"}, {"a"}},
+			want:  []string{join("a", "b"), join("a.1")},
+		},
+		{
+			name:    "windows reserved names and characters",
+			files:   [][]string{{"CON"}, {"com1.txt"}, {"a:b?"}, {"trailing. "}, {"..."}},
+			windows: true,
+			want:    []string{join("_CON"), join("_com1.txt"), join("a_b_"), join("trailing_"), join("_")},
+		},
+		{
+			name:    "windows case-insensitive duplicates",
+			files:   [][]string{{"README"}, {"readme"}},
+			windows: true,
+			want:    []string{join("README"), join("readme.1")},
+		},
+		{
+			name:  "windows rules only apply on windows",
+			files: [][]string{{"CON"}, {"a:b"}},
+			want:  []string{join("CON"), join("a:b")},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			meta := Metainfo{Name: "dir"}
+			for _, path := range test.files {
+				meta.Files = append(meta.Files, File{Path: path})
+			}
+			paths, err := meta.filePaths(root, test.windows)
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.want, paths)
+			}
+		})
+	}
+}
+
+func TestMetainfo_FilePaths_Invalid(t *testing.T) {
+	root := t.TempDir()
+	for name, meta := range map[string]Metainfo{
+		"parent directory":        {Name: "dir", Files: []File{{Path: []string{"..", "..", "etc", "passwd"}}}},
+		"nested parent directory": {Name: "dir", Files: []File{{Path: []string{"sub", "..", "..", "x"}}}},
+		"empty path":              {Name: "dir", Files: []File{{Path: []string{"", "."}}}},
+		"parent directory name":   {Name: ".."},
+		"dot name":                {Name: "."},
+		"file inside a file":      {Name: "dir", Files: []File{{Path: []string{"a"}}, {Path: []string{"a", "b"}}}},
+	} {
+		_, err := meta.FilePaths(root)
+		assert.Error(t, err, name)
+		_, err = NewFileStorage(root, meta)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMetainfo_FilePaths_SingleFile(t *testing.T) {
+	meta := Metainfo{Name: "movie/../../x.mkv"}
+	paths, err := meta.FilePaths("out")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{filepath.Join("out", "movie_.._.._x.mkv")}, paths)
+	}
+}
+
+func TestParseMetainfo_UTF8Paths(t *testing.T) {
+	raw, err := bencoding.Marshal(map[string]any{
+		"announce": "http://tracker",
+		"info": map[string]any{
+			"name":         "caf\xe9",
+			"name.utf-8":   "café",
+			"piece length": 8,
+			"pieces":       make([]byte, sha1.Size),
+			"files": []any{
+				map[string]any{"length": 1, "path": []string{"\xe9t\xe9"}, "path.utf-8": []string{"été"}},
+				map[string]any{"length": 1, "path": []string{"plain"}, "path.utf-8": []string{"bad\xff"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "café", meta.Name)
+	assert.Equal(t, []string{"été"}, meta.Files[0].Path)
+	assert.Equal(t, []string{"plain"}, meta.Files[1].Path)
+}
@@ -0,0 +1,280 @@
+package bytedribble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPTracker announces to a single HTTP or HTTPS tracker.
+//
+// See: https://www.bittorrent.org/beps/bep_0003.html#trackers
+type HTTPTracker struct {
+	client *http.Client
+	url    *url.URL
+
+	mu        sync.Mutex
+	noCompact bool   // whether the tracker refused a compact peer list
+	trackerID string // tracker id from a previous response, sent back with later announces
+}
+
+func NewHTTPTracker(client *http.Client, u *url.URL) *HTTPTracker {
+	return &HTTPTracker{
+		client: client,
+		url:    u,
+	}
+}
+
+// String returns the tracker's URL.
+func (t *HTTPTracker) String() string {
+	return t.url.String()
+}
+
+// Announce announces the local peer to the tracker and returns the peers it responds with. Compact peer lists are
+// requested unless the tracker has refused them before. If the tracker fails the request for a reason that mentions
+// compact peer lists, the request is retried without.
+//
+// See: https://www.bittorrent.org/beps/bep_0023.html
+func (t *HTTPTracker) Announce(ctx context.Context, req AnnounceRequest) (AnnounceResponse, error) {
+	t.mu.Lock()
+	compact := !t.noCompact
+	t.mu.Unlock()
+
+	resp, err := t.announce(ctx, req, compact)
+	var failure *TrackerFailureError
+	if compact && errors.As(err, &failure) && strings.Contains(strings.ToLower(failure.Reason), "compact") {
+		if resp, err = t.announce(ctx, req, false); err == nil {
+			t.mu.Lock()
+			t.noCompact = true
+			t.mu.Unlock()
+		}
+	}
+	return resp, err
+}
+
+func (t *HTTPTracker) announce(ctx context.Context, req AnnounceRequest, compact bool) (AnnounceResponse, error) {
+	httpReq, err := t.createRequest(ctx, req, compact)
+	if err != nil {
+		return AnnounceResponse{}, fmt.Errorf("failed to create tracker request: %w", err)
+	}
+	return t.sendRequest(httpReq)
+}
+
+func (t *HTTPTracker) createRequest(ctx context.Context, req AnnounceRequest, compact bool) (*http.Request, error) {
+	query := url.Values{}
+	query.Set("info_hash", string(req.InfoHash))
+	query.Set("peer_id", string(req.PeerID[:]))
+	if req.IP != nil {
+		query.Set("ip", req.IP.String())
+	}
+	query.Set("port", strconv.Itoa(req.Port))
+	query.Set("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	query.Set("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	query.Set("left", strconv.FormatInt(req.Left, 10))
+	if compact {
+		query.Set("compact", "1")
+	} else {
+		query.Set("compact", "0")
+	}
+	if req.Event != Empty {
+		query.Set("event", string(req.Event))
+	}
+	if req.NumWant >= 0 {
+		query.Set("numwant", strconv.Itoa(req.NumWant))
+	}
+	t.mu.Lock()
+	if t.trackerID != "" {
+		query.Set("trackerid", t.trackerID)
+	}
+	t.mu.Unlock()
+	return http.NewRequestWithContext(ctx, http.MethodGet, appendQuery(t.url, query), nil)
+}
+
+// appendQuery adds query to the tracker URL, which may already have a query string of its own (e.g. a passkey).
+func appendQuery(u *url.URL, query url.Values) string {
+	sep := "?"
+	if u.RawQuery != "" {
+		sep = "&"
+	}
+	return u.String() + sep + query.Encode()
+}
+
+// Scrape returns the tracker's statistics for each of the infohashes, in order. Torrents the tracker does not know
+// have zero statistics. It returns ErrScrapeUnsupported if the tracker's URL has no scrape equivalent.
+//
+// See: https://www.bittorrent.org/beps/bep_0048.html
+func (t *HTTPTracker) Scrape(ctx context.Context, infoHashes ...[]byte) ([]ScrapeResult, error) {
+	u, err := scrapeURL(t.url)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	for _, h := range infoHashes {
+		query.Add("info_hash", string(h))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, appendQuery(u, query), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scrape request: %w", err)
+	}
+	rawResp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rawResp.Body.Close()
+	if rawResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker responded with unexpected HTTP error code: %d", rawResp.StatusCode)
+	}
+
+	var resp scrapeResponse
+	dec := bencoding.NewDecoder(rawResp.Body)
+	dec.SetOptions(networkDecodeOptions)
+	if err := dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to parse scrape response: %w", err)
+	}
+	if resp.FailureReason != "" {
+		return nil, &TrackerFailureError{Reason: resp.FailureReason}
+	}
+	results := make([]ScrapeResult, len(infoHashes))
+	for i, h := range infoHashes {
+		results[i] = resp.Files[string(h)]
+	}
+	return results, nil
+}
+
+// scrapeURL derives a tracker's scrape URL from its announce URL by replacing "announce" at the start of the last path
+// element with "scrape".
+func scrapeURL(announce *url.URL) (*url.URL, error) {
+	i := strings.LastIndex(announce.Path, "/")
+	last := announce.Path[i+1:]
+	if !strings.HasPrefix(last, "announce") {
+		return nil, ErrScrapeUnsupported
+	}
+	u := *announce
+	u.Path = announce.Path[:i+1] + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+	return &u, nil
+}
+
+// scrapeResponse mirrors the bencoded body of a tracker's scrape response.
+type scrapeResponse struct {
+	FailureReason string                  `bencode:"failure reason"`
+	Files         map[string]ScrapeResult `bencode:"files"`
+}
+
+// trackerResponse mirrors the bencoded body of a tracker's announce response.
+type trackerResponse struct {
+	FailureReason string               `bencode:"failure reason"`
+	Interval      int                  `bencode:"interval"`
+	MinInterval   int                  `bencode:"min interval,omitempty"`
+	TrackerID     string               `bencode:"tracker id,omitempty"`
+	Complete      int                  `bencode:"complete,omitempty"`
+	Incomplete    int                  `bencode:"incomplete,omitempty"`
+	Peers         bencoding.RawMessage `bencode:"peers"`            // a list of dictionaries, or a compact string
+	Peers6        []byte               `bencode:"peers6,omitempty"` // compact IPv6 peers (BEP-7)
+}
+
+// trackerPeer is a peer in the dictionary model of a tracker's peer list.
+type trackerPeer struct {
+	PeerID string `bencode:"peer id"`
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+}
+
+func (t *HTTPTracker) sendRequest(req *http.Request) (AnnounceResponse, error) {
+	rawResp, err := t.client.Do(req)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	defer rawResp.Body.Close()
+	if rawResp.StatusCode != http.StatusOK {
+		return AnnounceResponse{}, fmt.Errorf("tracker responded with unexpected HTTP error code: %d", rawResp.StatusCode)
+	}
+
+	var resp trackerResponse
+	dec := bencoding.NewDecoder(rawResp.Body)
+	dec.SetOptions(networkDecodeOptions)
+	if err := dec.Decode(&resp); err != nil {
+		return AnnounceResponse{}, fmt.Errorf("failed to parse tracker response: %w", err)
+	}
+
+	if resp.FailureReason != "" {
+		return AnnounceResponse{}, &TrackerFailureError{Reason: resp.FailureReason}
+	}
+
+	if resp.Interval <= 0 {
+		return AnnounceResponse{}, errors.New("missing interval")
+	}
+
+	if resp.Peers == nil && resp.Peers6 == nil {
+		return AnnounceResponse{}, errors.New("missing peer list")
+	}
+	peers, err := parsePeers(resp.Peers)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	peers6, err := parseCompactPeers(resp.Peers6, net.IPv6len)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+
+	if resp.TrackerID != "" {
+		t.mu.Lock()
+		t.trackerID = resp.TrackerID
+		t.mu.Unlock()
+	}
+	return AnnounceResponse{
+		Interval:    time.Duration(resp.Interval) * time.Second,
+		MinInterval: time.Duration(resp.MinInterval) * time.Second,
+		Peers:       append(peers, peers6...),
+		Seeders:     resp.Complete,
+		Leechers:    resp.Incomplete,
+	}, nil
+}
+
+// parsePeers parses a tracker's peer list, which is either a list of dictionaries or a string of compact IPv4 peers.
+func parsePeers(raw bencoding.RawMessage) ([]PeerInfo, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if raw[0] != 'l' {
+		var compact []byte
+		if err := bencoding.Unmarshal(raw, &compact); err != nil {
+			return nil, fmt.Errorf("failed to parse peer list: %w", err)
+		}
+		return parseCompactPeers(compact, net.IPv4len)
+	}
+
+	var list []trackerPeer
+	if err := bencoding.UnmarshalWithOptions(raw, &list, networkDecodeOptions); err != nil {
+		return nil, fmt.Errorf("failed to parse peer list: %w", err)
+	}
+	var peers []PeerInfo
+	for _, p := range list {
+		pi := PeerInfo{}
+		switch len(p.PeerID) {
+		case 0:
+			// omitted by trackers honouring no_peer_id
+		case peerIDLen:
+			pi.PeerID = PeerIDFromString(p.PeerID)
+		default:
+			return nil, errors.New("invalid peer id")
+		}
+		if pi.IP = net.ParseIP(p.IP); pi.IP == nil {
+			return nil, errors.New("missing peer ip address")
+		}
+		if p.Port == 0 {
+			return nil, errors.New("missing peer port number")
+		}
+		pi.Port = p.Port
+		peers = append(peers, pi)
+	}
+	return peers, nil
+}
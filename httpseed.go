@@ -0,0 +1,125 @@
+package bytedribble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPSeed downloads pieces from a server that serves them by infohash and piece index.
+//
+// See: https://www.bittorrent.org/beps/bep_0017.html
+type HTTPSeed struct {
+	client   *http.Client
+	url      *url.URL
+	infoHash []byte
+}
+
+func NewHTTPSeed(client *http.Client, u *url.URL, target Metainfo) *HTTPSeed {
+	return &HTTPSeed{
+		client:   client,
+		url:      u,
+		infoHash: target.InfoHash(),
+	}
+}
+
+// String returns the HTTP seed's URL.
+func (s *HTTPSeed) String() string {
+	return s.url.String()
+}
+
+// A RetryAfterError is returned when a seed is too busy to serve a piece, and asks to be retried after a delay.
+type RetryAfterError struct {
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return "seed is busy, retry after " + e.Delay.String()
+}
+
+const (
+	// maxRetryAfterBody bounds the body of a busy response, which only holds a number of seconds.
+	maxRetryAfterBody = 64
+	// minRetryAfter is the shortest delay a busy seed can ask for, so that a seed replying 0 is not retried in a
+	// tight loop.
+	minRetryAfter = 5 * time.Second
+)
+
+// Download fetches the piece's missing blocks and verifies the piece against its hashes. If the seed is busy, the
+// returned error is a *RetryAfterError, whose delay is at least minRetryAfter.
+func (s *HTTPSeed) Download(ctx context.Context, p *Piece) error {
+	blocks := p.MissingBlocks()
+	query := url.Values{}
+	query.Set("info_hash", string(s.infoHash))
+	query.Set("piece", strconv.FormatUint(uint64(p.Index), 10))
+	if len(blocks) != p.NumBlocks() {
+		query.Set("ranges", formatRanges(blocks))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, appendQuery(s.url, query), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRetryAfterBody))
+		if err != nil {
+			return err
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(string(body)))
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("HTTP seed is busy with invalid retry delay %q", body)
+		}
+		delay := time.Duration(seconds) * time.Second
+		if delay < minRetryAfter {
+			delay = minRetryAfter
+		}
+		return &RetryAfterError{Delay: delay}
+	default:
+		return fmt.Errorf("HTTP seed responded with unexpected HTTP status code: %d", resp.StatusCode)
+	}
+
+	for _, b := range blocks {
+		payload := make([]byte, b.Length)
+		if _, err := io.ReadFull(resp.Body, payload); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("HTTP seed response for piece %d is too short: %w", p.Index, err)
+		}
+		p.AddBlockPayload(b, payload)
+	}
+	if n, _ := resp.Body.Read(make([]byte, 1)); n != 0 {
+		return fmt.Errorf("HTTP seed response for piece %d is too long", p.Index)
+	}
+	if !p.Valid() {
+		return fmt.Errorf("HTTP seed returned invalid data for piece %d", p.Index)
+	}
+	return nil
+}
+
+// formatRanges formats the byte ranges of blocks within their piece, merging adjacent blocks, like "0-16383,32768-49151".
+func formatRanges(blocks []Block) string {
+	var ranges []string
+	for i := 0; i < len(blocks); {
+		start := blocks[i].BeginOffset
+		end := start + blocks[i].Length
+		for i++; i < len(blocks) && blocks[i].BeginOffset == end; i++ {
+			end += blocks[i].Length
+		}
+		ranges = append(ranges, strconv.FormatUint(uint64(start), 10)+"-"+strconv.FormatUint(uint64(end-1), 10))
+	}
+	return strings.Join(ranges, ",")
+}
@@ -0,0 +1,108 @@
+package bytedribble
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHTTPSeed(t *testing.T, meta Metainfo, data []byte, busy *int) (*HTTPSeed, *[]string) {
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("info_hash") != string(meta.InfoHash()) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if *busy > 0 {
+			*busy--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("7\n"))
+			return
+		}
+		idx, _ := strconv.Atoi(query.Get("piece"))
+		piece := data[int64(idx)*meta.PieceSizeBytes:]
+		if int64(len(piece)) > meta.PieceSizeBytes {
+			piece = piece[:meta.PieceSizeBytes]
+		}
+		ranges = append(ranges, query.Get("ranges"))
+		if query.Get("ranges") == "" {
+			_, _ = w.Write(piece)
+			return
+		}
+		for _, rng := range strings.Split(query.Get("ranges"), ",") {
+			first, last, _ := strings.Cut(rng, "-")
+			start, _ := strconv.Atoi(first)
+			end, _ := strconv.Atoi(last)
+			_, _ = w.Write(piece[start : end+1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL + "/seed?passkey=secret")
+	return NewHTTPSeed(srv.Client(), u, meta), &ranges
+}
+
+func TestHTTPSeed_Download(t *testing.T) {
+	meta, data := newWebSeedTorrent(t, t.TempDir(), "file", map[string]string{"": "0123456789abcdefghijklmnopqrstuvwxyz"})
+	busy := 0
+	seed, ranges := newTestHTTPSeed(t, meta, data, &busy)
+
+	got, err := downloadAll(t, seed, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.Equal(t, []string{"", "", ""}, *ranges)
+
+	// only missing blocks are requested
+	*ranges = nil
+	p := meta.newPiece(1)
+	p.BlockSize = 4
+	p.AddBlockPayload(p.block(1), data[20:24])
+	assert.NoError(t, seed.Download(context.Background(), p))
+	assert.Equal(t, []string{"0-3,8-15"}, *ranges)
+	assert.Equal(t, data[16:32], p.Payload())
+}
+
+func TestHTTPSeed_Busy(t *testing.T) {
+	meta, data := newWebSeedTorrent(t, t.TempDir(), "file", map[string]string{"": "0123456789abcdefghijklmnopqrstuvwxyz"})
+	busy := 1
+	seed, _ := newTestHTTPSeed(t, meta, data, &busy)
+
+	err := seed.Download(context.Background(), meta.newPiece(0))
+	var retry *RetryAfterError
+	if assert.ErrorAs(t, err, &retry) {
+		assert.Equal(t, 7*time.Second, retry.Delay)
+	}
+	assert.NoError(t, seed.Download(context.Background(), meta.newPiece(0)))
+}
+
+func TestHTTPSeed_BusyWithoutDelay(t *testing.T) {
+	meta, _ := newWebSeedTorrent(t, t.TempDir(), "file", map[string]string{"": "0123456789abcdefghijklmnopqrstuvwxyz"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("0"))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	seed := NewHTTPSeed(srv.Client(), u, meta)
+
+	err := seed.Download(context.Background(), meta.newPiece(0))
+	var retry *RetryAfterError
+	if assert.ErrorAs(t, err, &retry) {
+		assert.Equal(t, minRetryAfter, retry.Delay)
+	}
+}
+
+func TestParseMetainfo_HTTPSeeds(t *testing.T) {
+	info := "4:infod6:lengthi4e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("h", 20) + "e"
+	meta, err := ParseMetainfo(strings.NewReader("d8:announce8:http://a9:httpseedsl12:http://seed/e" + info + "e"))
+	assert.NoError(t, err)
+	if assert.Len(t, meta.HTTPSeeds, 1) {
+		assert.Equal(t, "http://seed/", meta.HTTPSeeds[0].String())
+	}
+}
@@ -0,0 +1,233 @@
+package bytedribble
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxSelectOnly bounds the number of file indices a magnet link's so parameter may expand to.
+const maxSelectOnly = 1 << 16
+
+// Magnet is a magnet link identifying a torrent by its infohash.
+//
+// See: https://www.bittorrent.org/beps/bep_0009.html#magnet-uri-format
+type Magnet struct {
+	InfoHash    [sha1.Size]byte // xt=urn:btih:, or the truncated v2 infohash if only urn:btmh: is given
+	InfoHashV2  []byte          // xt=urn:btmh:, the SHA-256 infohash of v2 and hybrid torrents
+	DisplayName string          // dn
+	Trackers    []string        // tr
+	WebSeeds    []string        // ws
+	ExactLength int64           // xl, 0 if unknown
+	Peers       []string        // x.pe, as host:port
+	SelectOnly  []int           // so, indices of the files to download
+}
+
+// ParseMagnet parses a magnet link. The v1 infohash may be given in hex or base32, and the v2 infohash as a hex encoded
+// multihash.
+func ParseMagnet(uri string) (Magnet, error) {
+	rest, ok := cutPrefixFold(uri, "magnet:?")
+	if !ok {
+		return Magnet{}, errors.New("not a magnet link")
+	}
+	query, err := url.ParseQuery(rest)
+	if err != nil {
+		return Magnet{}, fmt.Errorf("invalid magnet link: %w", err)
+	}
+
+	var m Magnet
+	foundV1 := false
+	for _, xt := range query["xt"] {
+		if hash, ok := cutPrefixFold(xt, "urn:btih:"); ok && !foundV1 {
+			if m.InfoHash, err = parseInfoHash(hash); err != nil {
+				return Magnet{}, err
+			}
+			foundV1 = true
+		} else if hash, ok := cutPrefixFold(xt, "urn:btmh:"); ok && m.InfoHashV2 == nil {
+			if m.InfoHashV2, err = parseMultihash(hash); err != nil {
+				return Magnet{}, err
+			}
+		}
+	}
+	if !foundV1 {
+		if m.InfoHashV2 == nil {
+			return Magnet{}, errors.New("missing btih or btmh infohash (xt)")
+		}
+		copy(m.InfoHash[:], m.InfoHashV2)
+	}
+
+	m.DisplayName = query.Get("dn")
+	m.Trackers = query["tr"]
+	m.WebSeeds = query["ws"]
+	if xl := query.Get("xl"); xl != "" {
+		if m.ExactLength, err = strconv.ParseInt(xl, 10, 64); err != nil || m.ExactLength < 0 {
+			return Magnet{}, fmt.Errorf("invalid exact length (xl) %q", xl)
+		}
+	}
+	for _, peer := range query["x.pe"] {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return Magnet{}, fmt.Errorf("invalid peer address (x.pe): %w", err)
+		}
+		m.Peers = append(m.Peers, peer)
+	}
+	if so := query.Get("so"); so != "" {
+		if m.SelectOnly, err = parseSelectOnly(so); err != nil {
+			return Magnet{}, err
+		}
+	}
+	return m, nil
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+func parseInfoHash(s string) ([sha1.Size]byte, error) {
+	var hash [sha1.Size]byte
+	var b []byte
+	var err error
+	switch len(s) {
+	case hex.EncodedLen(sha1.Size):
+		b, err = hex.DecodeString(s)
+	case base32.StdEncoding.EncodedLen(sha1.Size):
+		b, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("invalid infohash %q: unexpected length", s)
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid infohash %q: %w", s, err)
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// sha256Multihash prefixes a SHA-256 hash in multihash format: the hash function code followed by the digest length.
+const sha256Multihash = "1220"
+
+func parseMultihash(s string) ([]byte, error) {
+	digest, ok := cutPrefixFold(s, sha256Multihash)
+	if !ok || len(digest) != hex.EncodedLen(sha256.Size) {
+		return nil, fmt.Errorf("invalid infohash %q: expected a SHA-256 multihash", s)
+	}
+	b, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid infohash %q: %w", s, err)
+	}
+	return b, nil
+}
+
+// parseSelectOnly parses a comma separated list of file indices and inclusive ranges, like "0,2,4-6".
+func parseSelectOnly(s string) ([]int, error) {
+	var indices []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid select-only index (so) %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid select-only range (so) %q", part)
+			}
+		}
+		if end-start >= maxSelectOnly-len(indices) {
+			return nil, errors.New("too many select-only indices (so)")
+		}
+		for i := start; i <= end; i++ {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// HasInfoHashV1 reports whether InfoHash is a v1 infohash, rather than just the truncated v2 infohash.
+func (m Magnet) HasInfoHashV1() bool {
+	return m.InfoHashV2 == nil || string(m.InfoHash[:]) != string(m.InfoHashV2[:sha1.Size])
+}
+
+// String formats the magnet link, with the infohashes in hex. The v1 infohash is left out if it is just the
+// truncated v2 infohash.
+func (m Magnet) String() string {
+	var b strings.Builder
+	b.WriteString("magnet:?")
+	if m.HasInfoHashV1() {
+		b.WriteString("xt=urn:btih:" + hex.EncodeToString(m.InfoHash[:]))
+		if m.InfoHashV2 != nil {
+			b.WriteString("&")
+		}
+	}
+	if m.InfoHashV2 != nil {
+		b.WriteString("xt=urn:btmh:" + sha256Multihash + hex.EncodeToString(m.InfoHashV2))
+	}
+	if m.DisplayName != "" {
+		b.WriteString("&dn=" + url.QueryEscape(m.DisplayName))
+	}
+	if m.ExactLength > 0 {
+		b.WriteString("&xl=" + strconv.FormatInt(m.ExactLength, 10))
+	}
+	for _, tr := range m.Trackers {
+		b.WriteString("&tr=" + url.QueryEscape(tr))
+	}
+	for _, ws := range m.WebSeeds {
+		b.WriteString("&ws=" + url.QueryEscape(ws))
+	}
+	for _, peer := range m.Peers {
+		b.WriteString("&x.pe=" + url.QueryEscape(peer))
+	}
+	if len(m.SelectOnly) > 0 {
+		b.WriteString("&so=" + formatSelectOnly(m.SelectOnly))
+	}
+	return b.String()
+}
+
+// formatSelectOnly formats file indices, collapsing consecutive runs into ranges.
+func formatSelectOnly(indices []int) string {
+	var parts []string
+	for i := 0; i < len(indices); {
+		j := i
+		for j+1 < len(indices) && indices[j+1] == indices[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, strconv.Itoa(indices[i])+"-"+strconv.Itoa(indices[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(indices[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Magnet returns a magnet link for the torrent, listing all of its trackers and web seeds.
+func (m Metainfo) Magnet() Magnet {
+	magnet := Magnet{
+		DisplayName: m.Name,
+		ExactLength: m.TotalSizeBytes,
+	}
+	copy(magnet.InfoHash[:], m.InfoHash())
+	magnet.InfoHashV2 = m.InfoHashV2()
+	seen := make(map[string]bool)
+	for _, tier := range m.AnnounceTiers() {
+		for _, u := range tier {
+			if tr := u.String(); !seen[tr] {
+				seen[tr] = true
+				magnet.Trackers = append(magnet.Trackers, tr)
+			}
+		}
+	}
+	for _, u := range m.WebSeeds {
+		magnet.WebSeeds = append(magnet.WebSeeds, u.String())
+	}
+	return magnet
+}
@@ -0,0 +1,83 @@
+package bytedribble
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	m, err := ParseMagnet("magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&dn=ubuntu%20server" +
+		"&tr=https%3A%2F%2Ftorrent.ubuntu.com%2Fannounce&tr=udp%3A%2F%2Ftracker%3A6969&ws=http%3A%2F%2Fmirror%2Fubuntu" +
+		"&xl=1474873344&x.pe=10.0.0.1:6881&x.pe=[::1]:6882&so=0,2,4-6")
+	assert.NoError(t, err)
+	assert.Equal(t, "cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33", hex.EncodeToString(m.InfoHash[:]))
+	assert.Equal(t, "ubuntu server", m.DisplayName)
+	assert.Equal(t, []string{"https://torrent.ubuntu.com/announce", "udp://tracker:6969"}, m.Trackers)
+	assert.Equal(t, []string{"http://mirror/ubuntu"}, m.WebSeeds)
+	assert.Equal(t, int64(1474873344), m.ExactLength)
+	assert.Equal(t, []string{"10.0.0.1:6881", "[::1]:6882"}, m.Peers)
+	assert.Equal(t, []int{0, 2, 4, 5, 6}, m.SelectOnly)
+
+	again, err := ParseMagnet(m.String())
+	assert.NoError(t, err)
+	assert.Equal(t, m, again)
+}
+
+func TestParseMagnet_Base32(t *testing.T) {
+	m, err := ParseMagnet("magnet:?xt=urn:btih:z47koxroxpjq4dng43rblyrcnpzv6lrt")
+	assert.NoError(t, err)
+	assert.Equal(t, "cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33", hex.EncodeToString(m.InfoHash[:]))
+}
+
+func TestParseMagnet_Invalid(t *testing.T) {
+	tests := []string{
+		"http://example.com",
+		"magnet:?dn=missing",
+		"magnet:?xt=urn:btih:abcd",
+		"magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&xl=-1",
+		"magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&x.pe=nope",
+		"magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&so=3-1",
+		"magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&so=0-999999999",
+		"magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&so=1,0-9223372036854775807",
+	}
+	for _, uri := range tests {
+		_, err := ParseMagnet(uri)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestMetainfo_Magnet(t *testing.T) {
+	f, err := os.Open("testdata/ubuntu-22.04.1-live-server-amd64.iso.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := ParseMetainfo(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33"+
+		"&dn=ubuntu-22.04.1-live-server-amd64.iso&xl=1474873344"+
+		"&tr=https%3A%2F%2Ftorrent.ubuntu.com%2Fannounce&tr=https%3A%2F%2Fipv6.torrent.ubuntu.com%2Fannounce",
+		meta.Magnet().String())
+}
+
+func TestParseMagnet_V2(t *testing.T) {
+	v2 := "1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e"
+	m, err := ParseMagnet("magnet:?xt=urn:btmh:" + v2 + "&dn=v2")
+	assert.NoError(t, err)
+	assert.Equal(t, v2[4:], hex.EncodeToString(m.InfoHashV2))
+	assert.Equal(t, v2[4:44], hex.EncodeToString(m.InfoHash[:]))
+	assert.Equal(t, "magnet:?xt=urn:btmh:"+v2+"&dn=v2", m.String())
+	assert.False(t, m.HasInfoHashV1())
+
+	hybrid := "magnet:?xt=urn:btih:cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33&xt=urn:btmh:" + v2
+	m, err = ParseMagnet(hybrid)
+	assert.NoError(t, err)
+	assert.Equal(t, "cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33", hex.EncodeToString(m.InfoHash[:]))
+	assert.Equal(t, hybrid, m.String())
+	assert.True(t, m.HasInfoHashV1())
+}
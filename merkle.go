@@ -0,0 +1,83 @@
+package bytedribble
+
+import (
+	"crypto/sha256"
+)
+
+// merkleBlockSize is the size of the blocks hashed into the leaves of a v2 merkle tree.
+//
+// See: https://www.bittorrent.org/beps/bep_0052.html#upgrade-path
+const merkleBlockSize = 16 << 10
+
+// MerkleHash identifies data by the root of a BEP-52 merkle tree built from the SHA-256 hashes of its 16 KiB blocks.
+type MerkleHash struct {
+	Root   [sha256.Size]byte
+	Length int // number of bytes covered by the tree; any bytes beyond this are padding
+	Leaves int // width of the tree, a power of two; leaves beyond the end of the data are zero
+}
+
+// Verify reports whether the first h.Length bytes of data hash to h.Root.
+func (h MerkleHash) Verify(data []byte) bool {
+	if len(data) < h.Length {
+		return false
+	}
+	return merkleDataRoot(data[:h.Length], h.Leaves) == h.Root
+}
+
+// merkleDataRoot returns the root of a merkle tree of the given width over the blocks of data.
+func merkleDataRoot(data []byte, width int) [sha256.Size]byte {
+	var leaves [][sha256.Size]byte
+	for off := 0; off < len(data); off += merkleBlockSize {
+		end := off + merkleBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		leaves = append(leaves, sha256.Sum256(data[off:end]))
+	}
+	return merkleRoot(leaves, width, [sha256.Size]byte{})
+}
+
+// merkleRoot returns the root of a merkle tree of the given width whose leaves are hashes followed by copies of pad.
+func merkleRoot(hashes [][sha256.Size]byte, width int, pad [sha256.Size]byte) [sha256.Size]byte {
+	if len(hashes) > width {
+		panic("merkle tree too narrow")
+	}
+	layer := make([][sha256.Size]byte, width)
+	copy(layer, hashes)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+// merklePadRoot returns the root of a merkle tree of the given width with only zero leaves, which stands in for the
+// hashes of pieces beyond the end of a file.
+func merklePadRoot(width int) [sha256.Size]byte {
+	var root [sha256.Size]byte
+	for ; width > 1; width /= 2 {
+		root = hashPair(root, root)
+	}
+	return root
+}
+
+func hashPair(left, right [sha256.Size]byte) [sha256.Size]byte {
+	var b [2 * sha256.Size]byte
+	copy(b[:], left[:])
+	copy(b[sha256.Size:], right[:])
+	return sha256.Sum256(b[:])
+}
+
+// nextPowerOfTwo returns the smallest power of two that is at least n.
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
@@ -1,124 +1,736 @@
 package bytedribble
 
 import (
-	"bufio"
 	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"github.com/bunsenmcdubbs/bytedribble/bencoding"
 	"io"
+	"net"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Metainfo describes a torrent.
 //
+// Version 1 torrents describe their contents with info.pieces and info.files, version 2 torrents with info.file tree
+// and piece layers, and hybrid torrents with both.
+//
 // See: https://www.bittorrent.org/beps/bep_0003.html#metainfo-files
+// See: https://www.bittorrent.org/beps/bep_0052.html#metainfo-files
 type Metainfo struct {
-	TrackerURL     *url.URL          // announce
-	Name           string            // info.name
-	Hashes         [][sha1.Size]byte // info.pieces
-	PieceSizeBytes int               // info.pieces length
-	TotalSizeBytes int               // info.length
-	Files          []struct {        // info.files
-		SizeBytes int      // length
-		Path      []string // path
+	TrackerURL     *url.URL          // announce, nil for trackerless torrents
+	AnnounceList   [][]*url.URL      // announce-list, tiers of trackers tried in order
+	WebSeeds       []*url.URL        // url-list, servers hosting the torrent's files
+	HTTPSeeds      []*url.URL        // httpseeds, servers serving the torrent's pieces
+	Nodes          []string          // nodes, DHT nodes to bootstrap from as host:port
+	Comment        string            // comment
+	CreatedBy      string            // created by
+	CreationDate   time.Time         // creation date, zero if unknown
+	Encoding       string            // encoding, the character set of Name and file paths
+	Name           string            // info.name.utf-8 or info.name, see FilePaths before using it as a file name
+	Private        bool              // info.private, restricts peers to those from the torrent's trackers
+	Source         string            // info.source, distinguishes otherwise identical torrents from different sources
+	MetaVersion    int               // info.meta version, 2 for v2 and hybrid torrents
+	Hashes         [][sha1.Size]byte // info.pieces, nil for v2-only torrents
+	PieceSizeBytes int64             // info.piece length
+	TotalSizeBytes int64             // info.length
+	Files          []File            // info.files, or info.file tree for multi-file v2-only torrents
+	FileTree       []File            // info.file tree flattened in path order (v2 and hybrid)
+
+	PieceLayers map[[sha256.Size]byte][][sha256.Size]byte // piece layers, keyed by pieces root
+	RawInfo     bencoding.RawMessage                      // original bytes of entire "info" field
+}
+
+// File describes one of a multi-file torrent's files.
+type File struct {
+	SizeBytes   int64             // length
+	Path        []string          // path.utf-8 or path, see FilePaths before using it as a file path
+	Attr        string            // attr, see IsPadding, IsExecutable, IsHidden and IsSymlink
+	SymlinkPath []string          // symlink path, the target of a symlink relative to the torrent's root
+	SHA1        []byte            // sha1, the optional hash of the file's contents
+	PiecesRoot  [sha256.Size]byte // pieces root, for files in the v2 file tree; zero for empty files
+}
+
+// IsPadding reports whether the file only exists to align the next file to a piece boundary. Padding files are
+// filled with zeros and are never written to disk.
+//
+// See: https://www.bittorrent.org/beps/bep_0047.html
+func (f File) IsPadding() bool {
+	return strings.ContainsRune(f.Attr, 'p')
+}
+
+// IsExecutable reports whether the file should be made executable.
+func (f File) IsExecutable() bool {
+	return strings.ContainsRune(f.Attr, 'x')
+}
+
+// IsHidden reports whether the file should be hidden.
+func (f File) IsHidden() bool {
+	return strings.ContainsRune(f.Attr, 'h')
+}
+
+// IsSymlink reports whether the file is a symbolic link to SymlinkPath, rather than a regular file.
+func (f File) IsSymlink() bool {
+	return strings.ContainsRune(f.Attr, 'l')
+}
+
+func (f File) validate() error {
+	if len(f.Path) == 0 {
+		return errors.New("empty file path")
+	}
+	if f.SizeBytes < 0 {
+		return fmt.Errorf("invalid length for file %q", f.Path)
+	}
+	if f.SHA1 != nil && len(f.SHA1) != sha1.Size {
+		return fmt.Errorf("invalid sha1 for file %q", f.Path)
+	}
+	if f.IsSymlink() {
+		if len(f.SymlinkPath) == 0 {
+			return fmt.Errorf("missing symlink path for file %q", f.Path)
+		}
+		for _, elem := range f.SymlinkPath {
+			if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, `/\`) {
+				return fmt.Errorf("invalid symlink path for file %q", f.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// rawMetainfo mirrors the bencoded structure of a metainfo file.
+type rawMetainfo struct {
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	Encoding     string     `bencode:"encoding,omitempty"`
+	Info         *rawInfo   `bencode:"info"`
+	Nodes        [][]any    `bencode:"nodes,omitempty"`
+	URLList      rawURLList `bencode:"url-list,omitempty"`
+	HTTPSeeds    []string   `bencode:"httpseeds,omitempty"`
+
+	PieceLayers map[string][]byte `bencode:"piece layers,omitempty"`
+}
+
+// rawInfo mirrors the bencoded structure of a metainfo file's info dictionary.
+type rawInfo struct {
+	Name        string    `bencode:"name"`
+	NameUTF8    string    `bencode:"name.utf-8,omitempty"`
+	PieceLength int64     `bencode:"piece length"`
+	Pieces      []byte    `bencode:"pieces"`
+	Length      int64     `bencode:"length,omitempty"`
+	Files       []rawFile `bencode:"files,omitempty"`
+	Private     bool      `bencode:"private,omitempty"`
+	Source      string    `bencode:"source,omitempty"`
+
+	MetaVersion int          `bencode:"meta version,omitempty"`
+	FileTree    *rawFileTree `bencode:"file tree,omitempty"`
+
+	raw bencoding.RawMessage
+}
+
+type rawFile struct {
+	Length      int64    `bencode:"length"`
+	Path        []string `bencode:"path"`
+	PathUTF8    []string `bencode:"path.utf-8,omitempty"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+	SHA1        []byte   `bencode:"sha1,omitempty"`
+}
+
+// UnmarshalBencode decodes the info dictionary while keeping its original bytes, which determine the infohash.
+func (i *rawInfo) UnmarshalBencode(data []byte) error {
+	type fields rawInfo // drop methods to avoid recursing
+	if err := bencoding.UnmarshalWithOptions(data, (*fields)(i), metainfoDecodeOptions); err != nil {
+		return err
+	}
+	return i.raw.UnmarshalBencode(data)
+}
+
+// rawURLList mirrors url-list, which may be either a single URL or a list of URLs.
+type rawURLList []string
+
+func (l *rawURLList) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		return bencoding.Unmarshal(data, (*[]string)(l))
+	}
+	var u string
+	if err := bencoding.Unmarshal(data, &u); err != nil {
+		return err
+	}
+	if u != "" {
+		*l = []string{u}
+	}
+	return nil
+}
+
+// rawFileTree mirrors a v2 file tree, in which directories map names to subtrees and files map "" to their details.
+type rawFileTree struct {
+	file     *rawFileTreeFile
+	children map[string]*rawFileTree
+}
+
+type rawFileTreeFile struct {
+	Length      int64    `bencode:"length"`
+	PiecesRoot  []byte   `bencode:"pieces root"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+// UnmarshalBencode decodes the whole tree in one pass. Decoding each directory separately would decode every entry
+// once per directory above it.
+func (t *rawFileTree) UnmarshalBencode(data []byte) error {
+	var entries map[string]any
+	if err := bencoding.UnmarshalWithOptions(data, &entries, metainfoDecodeOptions); err != nil {
+		return err
+	}
+	return t.fill(entries)
+}
+
+// fill builds the tree from its entries, as decoded into generic values.
+func (t *rawFileTree) fill(entries map[string]any) error {
+	for name, entry := range entries {
+		dict, ok := entry.(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid file tree entry %q", name)
+		}
+		if name == "" {
+			// re-encoding a file's details to decode them into their struct only costs their own size
+			data, err := bencoding.Marshal(dict)
+			if err != nil {
+				return err
+			}
+			t.file = &rawFileTreeFile{}
+			if err := bencoding.Unmarshal(data, t.file); err != nil {
+				return err
+			}
+			continue
+		}
+		child := &rawFileTree{}
+		if err := child.fill(dict); err != nil {
+			return err
+		}
+		if t.children == nil {
+			t.children = make(map[string]*rawFileTree)
+		}
+		t.children[name] = child
+	}
+	return nil
+}
+
+// flatten appends the files beneath t to files, in path order.
+func (t *rawFileTree) flatten(path []string, files []File) ([]File, error) {
+	if t.file != nil {
+		if len(t.children) != 0 || len(path) == 0 {
+			return nil, fmt.Errorf("invalid file tree entry %q", path)
+		}
+		f := File{
+			SizeBytes:   t.file.Length,
+			Path:        append([]string(nil), path...),
+			Attr:        t.file.Attr,
+			SymlinkPath: t.file.SymlinkPath,
+		}
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+		if f.SizeBytes > 0 {
+			if len(t.file.PiecesRoot) != sha256.Size {
+				return nil, fmt.Errorf("missing pieces root for file %q", path)
+			}
+			copy(f.PiecesRoot[:], t.file.PiecesRoot)
+		}
+		return append(files, f), nil
+	}
+	if len(t.children) == 0 {
+		return nil, fmt.Errorf("empty directory %q in file tree", path)
+	}
+	names := make([]string, 0, len(t.children))
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var err error
+	for _, name := range names {
+		if files, err = t.children[name].flatten(append(path, name), files); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// FileSpan is a contiguous range of bytes within one of a torrent's files.
+type FileSpan struct {
+	FileIndex  int   // index into Files, or 0 for a single-file torrent
+	FileOffset int64 // offset of the range within the file
+	Length     int64
+}
+
+// spansOf maps the byte range [offset, offset+length) of the concatenation of files with the given sizes onto the
+// individual files.
+func spansOf(fileSizes []int64, offset, length int64) []FileSpan {
+	var spans []FileSpan
+	var fileStart int64
+	for i, size := range fileSizes {
+		fileEnd := fileStart + size
+		if length > 0 && offset < fileEnd {
+			n := fileEnd - offset
+			if n > length {
+				n = length
+			}
+			if n > 0 {
+				spans = append(spans, FileSpan{FileIndex: i, FileOffset: offset - fileStart, Length: n})
+			}
+			offset += n
+			length -= n
+		}
+		fileStart = fileEnd
+	}
+	return spans
+}
+
+// fileSizes returns the sizes of the torrent's files, in the order of Files.
+func (m Metainfo) fileSizes() []int64 {
+	if m.Files == nil {
+		return []int64{m.TotalSizeBytes}
+	}
+	sizes := make([]int64, len(m.Files))
+	for i, file := range m.Files {
+		sizes[i] = file.SizeBytes
+	}
+	return sizes
+}
+
+// v2Only reports whether the torrent only has v2 pieces, which never span files.
+func (m Metainfo) v2Only() bool {
+	return m.Hashes == nil && m.MetaVersion == 2
+}
+
+// filePieces returns the number of pieces of a file in a v2-only torrent.
+func (m Metainfo) filePieces(size int64) int {
+	return int((size + m.PieceSizeBytes - 1) / m.PieceSizeBytes)
+}
+
+// PieceSize returns the size of the piece at index idx, which is smaller than PieceSizeBytes for the torrent's last
+// piece and, in v2-only torrents, for the last piece of each file. It returns 0 if there is no such piece.
+func (m Metainfo) PieceSize(idx int) int64 {
+	if idx < 0 || idx >= m.NumPieces() {
+		return 0
+	}
+	if m.v2Only() {
+		return m.FileSpans(idx)[0].Length
+	}
+	size := m.PieceSizeBytes
+	if rest := m.TotalSizeBytes - int64(idx)*m.PieceSizeBytes; rest < size {
+		size = rest
+	}
+	return size
+}
+
+// FileSpans maps the piece at index idx onto the byte ranges of the files it covers, in order. Padding files are
+// included. It returns nil if there is no such piece.
+func (m Metainfo) FileSpans(idx int) []FileSpan {
+	if idx < 0 || idx >= m.NumPieces() {
+		return nil
+	}
+	if m.v2Only() {
+		for i, file := range m.FileTree {
+			numPieces := m.filePieces(file.SizeBytes)
+			if idx >= numPieces {
+				idx -= numPieces
+				continue
+			}
+			offset := int64(idx) * m.PieceSizeBytes
+			length := m.PieceSizeBytes
+			if rest := file.SizeBytes - offset; rest < length {
+				length = rest
+			}
+			return []FileSpan{{FileIndex: i, FileOffset: offset, Length: length}}
+		}
+		return nil
+	}
+	return spansOf(m.fileSizes(), int64(idx)*m.PieceSizeBytes, m.PieceSize(idx))
+}
+
+// PiecesForFile returns the range [begin, end) of pieces holding any of the data of the file at index idx, which is
+// empty for empty files. Pieces at either end may be shared with neighbouring files.
+func (m Metainfo) PiecesForFile(idx int) (begin, end int) {
+	sizes := m.fileSizes()
+	if idx < 0 || idx >= len(sizes) {
+		return 0, 0
+	}
+	if m.v2Only() {
+		for _, file := range m.FileTree[:idx] {
+			begin += m.filePieces(file.SizeBytes)
+		}
+		return begin, begin + m.filePieces(m.FileTree[idx].SizeBytes)
+	}
+	var offset int64
+	for _, size := range sizes[:idx] {
+		offset += size
+	}
+	return m.PiecesForRange(offset, sizes[idx])
+}
+
+// PiecesForRange returns the range [begin, end) of pieces holding any of the bytes [offset, offset+length) of the
+// concatenation of the torrent's files. The byte range is clipped to the torrent's contents.
+func (m Metainfo) PiecesForRange(offset, length int64) (begin, end int) {
+	if offset < 0 {
+		length += offset
+		offset = 0
+	}
+	if rest := m.TotalSizeBytes - offset; length > rest {
+		length = rest
+	}
+	if length <= 0 {
+		return 0, 0
 	}
-	RawInfo map[string]any // original map of entire "info" field
+	if m.v2Only() {
+		spans := spansOf(m.fileSizes(), offset, length)
+		first, last := spans[0], spans[len(spans)-1]
+		begin, _ = m.PiecesForFile(first.FileIndex)
+		begin += int(first.FileOffset / m.PieceSizeBytes)
+		end, _ = m.PiecesForFile(last.FileIndex)
+		end += m.filePieces(last.FileOffset + last.Length)
+		return begin, end
+	}
+	return int(offset / m.PieceSizeBytes), int((offset + length + m.PieceSizeBytes - 1) / m.PieceSizeBytes)
+}
+
+// metainfoDecodeOptions bounds the resources spent decoding a metainfo file, which usually comes from an untrusted
+// source. The limits are generous enough for any real torrent.
+var metainfoDecodeOptions = bencoding.DecodeOptions{
+	MaxDepth:        256,       // v2 file trees nest a dictionary for each directory
+	MaxStringLength: 64 << 20,  // pieces and piece layers of very large torrents
+	MaxTotalBytes:   128 << 20, // the whole file
 }
 
 // ParseMetainfo parses a bencoded metainfo file
 func ParseMetainfo(raw io.Reader) (Metainfo, error) {
-	dict, err := bencoding.UnmarshalDict(bufio.NewReader(raw))
-	if err != nil {
+	var rm rawMetainfo
+	dec := bencoding.NewDecoder(raw)
+	dec.SetOptions(metainfoDecodeOptions)
+	if err := dec.Decode(&rm); err != nil {
 		return Metainfo{}, fmt.Errorf("bencoding: %w", err)
 	}
 
 	var meta Metainfo
-	rawURL, ok := dict["announce"].(string)
-	if !ok {
+	var err error
+	for _, tier := range rm.AnnounceList {
+		var urls []*url.URL
+		for _, rawURL := range tier {
+			// Unusable entries are skipped rather than rejecting the whole torrent, since other trackers may work.
+			if u, err := url.Parse(rawURL); err == nil && rawURL != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) > 0 {
+			meta.AnnounceList = append(meta.AnnounceList, urls)
+		}
+	}
+	switch {
+	case rm.Announce != "":
+		meta.TrackerURL, err = url.Parse(rm.Announce)
+		if err != nil {
+			return Metainfo{}, err
+		}
+	case len(meta.AnnounceList) > 0:
+		meta.TrackerURL = meta.AnnounceList[0][0]
+	}
+
+	for _, node := range rm.Nodes {
+		// each node is a [host, port] pair
+		if len(node) != 2 {
+			continue
+		}
+		host, _ := node[0].(string)
+		port, _ := node[1].(int64)
+		if host != "" && port > 0 && port <= 65535 {
+			meta.Nodes = append(meta.Nodes, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+		}
+	}
+	if meta.TrackerURL == nil && meta.Nodes == nil {
 		return Metainfo{}, errors.New("missing announce url")
 	}
-	meta.TrackerURL, err = url.Parse(rawURL)
-	if err != nil {
-		return Metainfo{}, err
+
+	meta.Comment = rm.Comment
+	meta.CreatedBy = rm.CreatedBy
+	if rm.CreationDate != 0 {
+		meta.CreationDate = time.Unix(rm.CreationDate, 0)
+	}
+	meta.Encoding = rm.Encoding
+
+	for _, rawURL := range rm.URLList {
+		if u, err := url.Parse(rawURL); err == nil && rawURL != "" {
+			meta.WebSeeds = append(meta.WebSeeds, u)
+		}
+	}
+	for _, rawURL := range rm.HTTPSeeds {
+		if u, err := url.Parse(rawURL); err == nil && rawURL != "" {
+			meta.HTTPSeeds = append(meta.HTTPSeeds, u)
+		}
 	}
 
-	info, ok := dict["info"].(map[string]any)
-	if !ok || info == nil {
+	if rm.Info == nil {
 		return Metainfo{}, errors.New("missing info")
 	}
-	meta.RawInfo = info
+	meta.RawInfo = rm.Info.raw
+	info := rm.Info
 
-	meta.Name, ok = info["name"].(string)
-	if !ok {
+	meta.Name = info.Name
+	if info.NameUTF8 != "" && utf8.ValidString(info.NameUTF8) {
+		meta.Name = info.NameUTF8
+	}
+	if meta.Name == "" {
 		return Metainfo{}, errors.New("missing name")
 	}
+	meta.Private = info.Private
+	meta.Source = info.Source
 
-	meta.PieceSizeBytes, ok = info["piece length"].(int)
-	if !ok {
-		return Metainfo{}, errors.New("missing number of pieces")
+	meta.PieceSizeBytes = info.PieceLength
+	if meta.PieceSizeBytes <= 0 {
+		return Metainfo{}, errors.New("missing piece length")
 	}
 
-	var numPieces int
-	if length, ok := info["length"].(int); ok {
-		meta.TotalSizeBytes = length
+	switch info.MetaVersion {
+	case 0, 1:
+		meta.MetaVersion = 1
+	case 2:
+		meta.MetaVersion = 2
+		if err := parseFileTree(&meta, info, rm.PieceLayers); err != nil {
+			return Metainfo{}, err
+		}
+	default:
+		return Metainfo{}, fmt.Errorf("unsupported meta version %d", info.MetaVersion)
 	}
 
-	if files, ok := info["files"].([]any); ok {
+	if meta.MetaVersion == 2 && info.Pieces == nil && info.Length == 0 && info.Files == nil {
+		// v2-only
+		for _, file := range meta.FileTree {
+			meta.TotalSizeBytes += file.SizeBytes
+		}
+		if len(meta.FileTree) > 1 || len(meta.FileTree[0].Path) > 1 || meta.FileTree[0].Path[0] != info.Name {
+			meta.Files = meta.FileTree
+		}
+		return meta, nil
+	}
+
+	if err := parsePieces(&meta, info); err != nil {
+		return Metainfo{}, err
+	}
+	if meta.MetaVersion == 2 && meta.numPiecesV2() != len(meta.Hashes) {
+		return Metainfo{}, errors.New("v1 pieces of hybrid torrent are not aligned with its file tree")
+	}
+	return meta, nil
+}
+
+// parsePieces fills in the v1 description of a torrent's contents.
+func parsePieces(meta *Metainfo, info *rawInfo) error {
+	meta.TotalSizeBytes = info.Length
+	if info.Files != nil {
 		if meta.TotalSizeBytes != 0 {
-			return Metainfo{}, errors.New("cannot specify total size with multiple files (length and files)")
+			return errors.New("cannot specify total size with multiple files (length and files)")
 		}
 
-		for _, file := range files {
-			parsedFile := struct { // info.files
-				SizeBytes int      // length
-				Path      []string // path
-			}{}
-			fileDict, ok := file.(map[string]any)
-			if !ok {
-				return Metainfo{}, errors.New("malformed metadata for child file")
+		for _, file := range info.Files {
+			if file.Path == nil {
+				return errors.New("missing child file path")
 			}
-			parsedFile.SizeBytes, ok = fileDict["length"].(int)
-			if !ok {
-				return Metainfo{}, errors.New("missing child file length")
+			f := File{
+				SizeBytes:   file.Length,
+				Path:        file.Path,
+				Attr:        file.Attr,
+				SymlinkPath: file.SymlinkPath,
+				SHA1:        file.SHA1,
 			}
-			path, ok := fileDict["path"].([]any)
-			if !ok {
-				return Metainfo{}, errors.New("missing child file path")
+			// path.utf-8 is only an alternative to path, so an unusable one is ignored
+			if len(file.PathUTF8) > 0 && validUTF8(file.PathUTF8) {
+				f.Path = file.PathUTF8
 			}
-			for _, name := range path {
-				nameString, ok := name.(string)
-				if !ok {
-					return Metainfo{}, errors.New("child path malformed")
-				}
-				parsedFile.Path = append(parsedFile.Path, nameString)
+			if err := f.validate(); err != nil {
+				return err
 			}
-			meta.Files = append(meta.Files, parsedFile)
-			meta.TotalSizeBytes += parsedFile.SizeBytes
+			meta.Files = append(meta.Files, f)
+			meta.TotalSizeBytes += file.Length
 		}
 	}
-	numPieces = (meta.TotalSizeBytes + meta.PieceSizeBytes - 1) / meta.PieceSizeBytes
+	numPieces := (meta.TotalSizeBytes + meta.PieceSizeBytes - 1) / meta.PieceSizeBytes
 
 	if meta.TotalSizeBytes == 0 {
-		return Metainfo{}, errors.New("must specify either file size or include child file metadata")
+		return errors.New("must specify either file size or include child file metadata")
 	}
 
-	hashes, ok := info["pieces"].(string)
-	if !ok {
-		return Metainfo{}, errors.New("missing piece hashes")
+	if info.Pieces == nil {
+		return errors.New("missing piece hashes")
 	}
-	if len([]byte(hashes)) != numPieces*sha1.Size {
-		return Metainfo{}, fmt.Errorf("%w: expected %d bytes, got %d)", errors.New("piece hashes are invalid"), numPieces*sha1.Size, len([]byte(hashes)))
+	if int64(len(info.Pieces)) != numPieces*sha1.Size {
+		return fmt.Errorf("%w: expected %d bytes, got %d)", errors.New("piece hashes are invalid"), numPieces*sha1.Size, len(info.Pieces))
 	}
 	meta.Hashes = make([][sha1.Size]byte, numPieces, numPieces)
 	for i := range meta.Hashes {
-		meta.Hashes[i] = *(*[sha1.Size]byte)([]byte(hashes)[i*sha1.Size : (i+1)*sha1.Size])
+		meta.Hashes[i] = *(*[sha1.Size]byte)(info.Pieces[i*sha1.Size : (i+1)*sha1.Size])
 	}
+	return nil
+}
 
-	return meta, nil
+// parseFileTree fills in the v2 description of a torrent's contents, checking each piece layer against the root of
+// its file's merkle tree.
+//
+// See: https://www.bittorrent.org/beps/bep_0052.html#metainfo-files
+func parseFileTree(meta *Metainfo, info *rawInfo, layers map[string][]byte) error {
+	if meta.PieceSizeBytes < merkleBlockSize || meta.PieceSizeBytes&(meta.PieceSizeBytes-1) != 0 {
+		return errors.New("piece length must be a power of two of at least 16 KiB")
+	}
+	if info.FileTree == nil {
+		return errors.New("missing file tree")
+	}
+	var err error
+	if meta.FileTree, err = info.FileTree.flatten(nil, nil); err != nil {
+		return err
+	}
+
+	pad := merklePadRoot(int(meta.PieceSizeBytes / merkleBlockSize))
+	for _, file := range meta.FileTree {
+		if file.SizeBytes <= meta.PieceSizeBytes {
+			continue // the pieces root is the hash of the file's only piece
+		}
+		numPieces := int((file.SizeBytes + meta.PieceSizeBytes - 1) / meta.PieceSizeBytes)
+		layer, ok := layers[string(file.PiecesRoot[:])]
+		if !ok {
+			return fmt.Errorf("missing piece layer for file %q", file.Path)
+		}
+		if len(layer) != numPieces*sha256.Size {
+			return fmt.Errorf("piece layer for file %q is invalid: expected %d bytes, got %d", file.Path, numPieces*sha256.Size, len(layer))
+		}
+		hashes := make([][sha256.Size]byte, numPieces)
+		for i := range hashes {
+			hashes[i] = *(*[sha256.Size]byte)(layer[i*sha256.Size : (i+1)*sha256.Size])
+		}
+		if merkleRoot(hashes, nextPowerOfTwo(numPieces), pad) != file.PiecesRoot {
+			return fmt.Errorf("piece layer for file %q does not match its pieces root", file.Path)
+		}
+		if meta.PieceLayers == nil {
+			meta.PieceLayers = make(map[[sha256.Size]byte][][sha256.Size]byte)
+		}
+		meta.PieceLayers[file.PiecesRoot] = hashes
+	}
+	return nil
+}
+
+// NumPieces returns the number of pieces in the torrent. In v2 torrents each file starts a new piece.
+func (m Metainfo) NumPieces() int {
+	if m.Hashes != nil {
+		return len(m.Hashes)
+	}
+	return m.numPiecesV2()
+}
+
+func (m Metainfo) numPiecesV2() int {
+	var n int
+	for _, file := range m.FileTree {
+		n += m.filePieces(file.SizeBytes)
+	}
+	return n
+}
+
+// newPiece returns the piece at index idx, with the hashes needed to verify it.
+func (m Metainfo) newPiece(idx int) *Piece {
+	p := &Piece{
+		Index:     uint32(idx),
+		BlockSize: DefaultBlockLength,
+	}
+	p.Size = uint32(m.PieceSize(idx))
+	if m.Hashes != nil {
+		p.Hash = &m.Hashes[idx]
+	}
+	if m.MetaVersion == 2 {
+		p.HashV2 = m.pieceHashV2(idx)
+	}
+	return p
 }
 
+// pieceHashV2 returns the merkle hash of the piece at index idx of a v2 torrent.
+func (m Metainfo) pieceHashV2(idx int) *MerkleHash {
+	for _, file := range m.FileTree {
+		numPieces := m.filePieces(file.SizeBytes)
+		if idx >= numPieces {
+			idx -= numPieces
+			continue
+		}
+		length := m.PieceSizeBytes
+		if rest := file.SizeBytes - int64(idx)*m.PieceSizeBytes; rest < length {
+			length = rest
+		}
+		if numPieces == 1 {
+			return &MerkleHash{
+				Root:   file.PiecesRoot,
+				Length: int(length),
+				Leaves: nextPowerOfTwo(int((length + merkleBlockSize - 1) / merkleBlockSize)),
+			}
+		}
+		return &MerkleHash{
+			Root:   m.PieceLayers[file.PiecesRoot][idx],
+			Length: int(length),
+			Leaves: int(m.PieceSizeBytes / merkleBlockSize),
+		}
+	}
+	return nil
+}
+
+// AnnounceTiers returns the tiers of trackers to announce to. As required by BEP-12, announce-list takes precedence
+// over announce when it is present.
+//
+// See: https://www.bittorrent.org/beps/bep_0012.html
+func (m Metainfo) AnnounceTiers() [][]*url.URL {
+	if len(m.AnnounceList) > 0 {
+		return m.AnnounceList
+	}
+	if m.TrackerURL == nil {
+		return nil
+	}
+	return [][]*url.URL{{m.TrackerURL}}
+}
+
+// InfoHash returns the 20 byte infohash that identifies the torrent to trackers and peers: the SHA-1 hash of the info
+// dictionary exactly as it appeared in the metainfo file, or for v2-only torrents the truncated v2 infohash.
 func (m Metainfo) InfoHash() []byte {
-	hash := sha1.Sum(bencoding.MarshalDict(m.RawInfo))
+	if m.MetaVersion == 2 && m.Hashes == nil {
+		return m.InfoHashV2()[:sha1.Size]
+	}
+	hash := sha1.Sum(m.RawInfo)
 	return hash[:]
 }
+
+// InfoHashV2 returns the SHA-256 hash of the info dictionary of a v2 or hybrid torrent, or nil for v1 torrents.
+func (m Metainfo) InfoHashV2() []byte {
+	if m.MetaVersion != 2 {
+		return nil
+	}
+	hash := sha256.Sum256(m.RawInfo)
+	return hash[:]
+}
+
+// SwarmInfoHashes returns the 20 byte infohashes of each swarm sharing the torrent. Hybrid torrents are shared by both
+// a v1 swarm and a v2 swarm, identified by the truncated v2 infohash.
+func (m Metainfo) SwarmInfoHashes() [][]byte {
+	if m.MetaVersion == 2 && m.Hashes != nil {
+		return [][]byte{m.InfoHash(), m.InfoHashV2()[:sha1.Size]}
+	}
+	return [][]byte{m.InfoHash()}
+}
+
+// validUTF8 reports whether every element of path is valid UTF-8.
+func validUTF8(path []string) bool {
+	for _, elem := range path {
+		if !utf8.ValidString(elem) {
+			return false
+		}
+	}
+	return true
+}
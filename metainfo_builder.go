@@ -0,0 +1,207 @@
+package bytedribble
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	minAutoPieceSize = 1 << 14 // 16 KiB
+	maxAutoPieceSize = 1 << 24 // 16 MiB
+	targetNumPieces  = 1500
+)
+
+// MetainfoBuilder creates metainfo files describing local data.
+//
+// See: https://www.bittorrent.org/beps/bep_0003.html#metainfo-files
+type MetainfoBuilder struct {
+	Path           string    // file or directory to describe
+	AnnounceURLs   []string  // announce, followed by backup trackers (announce-list)
+	Comment        string    // comment
+	CreatedBy      string    // created by
+	CreationDate   time.Time // creation date, omitted if zero
+	Private        bool      // info.private
+	PieceSizeBytes int64     // info.piece length, chosen from the total size if zero
+	Parallelism    int       // number of pieces hashed at once, defaults to the number of CPUs
+}
+
+// Build walks the builder's path, hashes its contents and returns the canonical bencoding of the resulting metainfo
+// file. A directory produces a multi-file torrent containing every regular file beneath it, in lexical order.
+func (b MetainfoBuilder) Build(ctx context.Context) ([]byte, error) {
+	if len(b.AnnounceURLs) == 0 {
+		return nil, errors.New("missing announce url")
+	}
+	root, err := filepath.Abs(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &rawInfo{
+		Name:    filepath.Base(root),
+		Private: b.Private,
+	}
+	var paths []string
+	var sizes []int64
+	if stat.IsDir() {
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			sizes = append(sizes, fi.Size())
+			info.Files = append(info.Files, rawFile{
+				Length: fi.Size(),
+				Path:   splitPath(rel),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(info.Files) == 0 {
+			return nil, fmt.Errorf("no files found in %s", root)
+		}
+	} else {
+		paths = []string{root}
+		sizes = []int64{stat.Size()}
+		info.Length = stat.Size()
+	}
+
+	var totalSize int64
+	for _, size := range sizes {
+		totalSize += size
+	}
+	if totalSize == 0 {
+		return nil, errors.New("cannot create a torrent of empty files")
+	}
+	info.PieceLength = b.PieceSizeBytes
+	if info.PieceLength == 0 {
+		info.PieceLength = choosePieceSize(totalSize)
+	}
+	if info.PieceLength < 0 {
+		return nil, errors.New("piece size must be positive")
+	}
+
+	parallelism := b.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	if info.Pieces, err = hashPieces(ctx, paths, sizes, info.PieceLength, parallelism); err != nil {
+		return nil, err
+	}
+
+	meta := rawMetainfo{
+		Announce:  b.AnnounceURLs[0],
+		Comment:   b.Comment,
+		CreatedBy: b.CreatedBy,
+		Info:      info,
+	}
+	if len(b.AnnounceURLs) > 1 {
+		for _, u := range b.AnnounceURLs {
+			meta.AnnounceList = append(meta.AnnounceList, []string{u})
+		}
+	}
+	if !b.CreationDate.IsZero() {
+		meta.CreationDate = b.CreationDate.Unix()
+	}
+	return bencoding.Marshal(meta)
+}
+
+// choosePieceSize picks the smallest power of two that keeps the number of pieces near targetNumPieces.
+func choosePieceSize(totalSize int64) int64 {
+	size := int64(minAutoPieceSize)
+	for size < maxAutoPieceSize && totalSize/size > targetNumPieces {
+		size *= 2
+	}
+	return size
+}
+
+// splitPath splits a relative OS path into its components.
+func splitPath(rel string) []string {
+	var parts []string
+	for rel != "" && rel != "." {
+		dir, file := filepath.Split(rel)
+		parts = append([]string{file}, parts...)
+		rel = filepath.Clean(dir)
+		if rel == string(filepath.Separator) {
+			break
+		}
+	}
+	return parts
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of every piece of the concatenated files.
+func hashPieces(ctx context.Context, paths []string, sizes []int64, pieceSize int64, parallelism int) ([]byte, error) {
+	var totalSize int64
+	for _, size := range sizes {
+		totalSize += size
+	}
+	numPieces := (totalSize + pieceSize - 1) / pieceSize
+	hashes := make([]byte, numPieces*sha1.Size)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(parallelism)
+	for i := int64(0); i < numPieces; i++ {
+		i := i
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			length := pieceSize
+			if i == numPieces-1 {
+				length = totalSize - i*pieceSize
+			}
+			h := sha1.New()
+			for _, span := range spansOf(sizes, i*pieceSize, length) {
+				if err := copyFileRange(h, paths[span.FileIndex], span.FileOffset, span.Length); err != nil {
+					return err
+				}
+			}
+			h.Sum(hashes[i*sha1.Size : i*sha1.Size : (i+1)*sha1.Size])
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// copyFileRange copies length bytes starting at offset in the named file to w.
+func copyFileRange(w io.Writer, path string, offset, length int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(w, io.NewSectionReader(f, offset, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("%s changed size while hashing", path)
+	}
+	return nil
+}
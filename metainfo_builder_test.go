@@ -0,0 +1,113 @@
+package bytedribble
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetainfoBuilder_SingleFile(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := MetainfoBuilder{
+		Path:           path,
+		AnnounceURLs:   []string{"http://tracker/announce"},
+		CreatedBy:      "test",
+		CreationDate:   time.Unix(1600000000, 0),
+		PieceSizeBytes: 32,
+	}.Build(context.Background())
+	assert.NoError(t, err)
+
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "data.bin", meta.Name)
+	assert.Equal(t, int64(100), meta.TotalSizeBytes)
+	assert.Equal(t, int64(32), meta.PieceSizeBytes)
+	if assert.Len(t, meta.Hashes, 4) {
+		assert.Equal(t, sha1.Sum(data[:32]), meta.Hashes[0])
+		assert.Equal(t, sha1.Sum(data[96:]), meta.Hashes[3])
+	}
+
+	var rm rawMetainfo
+	assert.NoError(t, bencoding.UnmarshalStrict(raw, &rm))
+	assert.Equal(t, "test", rm.CreatedBy)
+	assert.Equal(t, int64(1600000000), rm.CreationDate)
+	assert.Nil(t, rm.AnnounceList)
+	assert.False(t, rm.Info.Private)
+}
+
+func TestMetainfoBuilder_Directory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	files := map[string]string{
+		"b.txt":         "bbbbbbbbbb",
+		"a/one.txt":     "1",
+		"a/two.txt":     "22222",
+		"a/empty.txt":   "",
+		"c/d/three.txt": "333333333333",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	concatenated := []byte(files["a/one.txt"] + files["a/two.txt"] + files["b.txt"] + files["c/d/three.txt"])
+
+	raw, err := MetainfoBuilder{
+		Path:           dir,
+		AnnounceURLs:   []string{"http://one/announce", "udp://two:6969"},
+		Comment:        "hello",
+		Private:        true,
+		PieceSizeBytes: 16,
+		Parallelism:    2,
+	}.Build(context.Background())
+	assert.NoError(t, err)
+
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "dir", meta.Name)
+	assert.Equal(t, "http://one/announce", meta.TrackerURL.String())
+	assert.Equal(t, int64(len(concatenated)), meta.TotalSizeBytes)
+	var paths [][]string
+	for _, f := range meta.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, [][]string{{"a", "empty.txt"}, {"a", "one.txt"}, {"a", "two.txt"}, {"b.txt"}, {"c", "d", "three.txt"}}, paths)
+	if assert.Len(t, meta.Hashes, 2) {
+		assert.Equal(t, sha1.Sum(concatenated[:16]), meta.Hashes[0])
+		assert.Equal(t, sha1.Sum(concatenated[16:]), meta.Hashes[1])
+	}
+
+	var rm rawMetainfo
+	assert.NoError(t, bencoding.UnmarshalStrict(raw, &rm))
+	assert.Equal(t, "hello", rm.Comment)
+	assert.Equal(t, [][]string{{"http://one/announce"}, {"udp://two:6969"}}, rm.AnnounceList)
+	assert.True(t, rm.Info.Private)
+	assert.Zero(t, rm.CreationDate)
+}
+
+func TestChoosePieceSize(t *testing.T) {
+	assert.Equal(t, int64(1<<14), choosePieceSize(100))
+	assert.Equal(t, int64(1<<20), choosePieceSize(1<<30))
+	assert.Equal(t, int64(1<<24), choosePieceSize(1<<40))
+}
+
+func TestSpansOf(t *testing.T) {
+	sizes := []int64{3, 0, 4, 5}
+	assert.Equal(t, []FileSpan{{0, 1, 2}, {2, 0, 4}, {3, 0, 1}}, spansOf(sizes, 1, 7))
+	assert.Equal(t, []FileSpan{{3, 2, 3}}, spansOf(sizes, 9, 10))
+}
@@ -1,9 +1,17 @@
 package bytedribble
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseMetainfo_Ubuntu(t *testing.T) {
@@ -17,5 +25,454 @@ func TestParseMetainfo_Ubuntu(t *testing.T) {
 	assert.Equal(t, "https://torrent.ubuntu.com/announce", meta.TrackerURL.String())
 	assert.Equal(t, "ubuntu-22.04.1-live-server-amd64.iso", meta.Name)
 	assert.Equal(t, 5627, len(meta.Hashes))
-	assert.Equal(t, 1474873344, meta.TotalSizeBytes)
+	assert.Equal(t, int64(1474873344), meta.TotalSizeBytes)
+	assert.Equal(t, "cf3ea75e2ebbd30e0da6e6e215e2226bf35f2e33", hex.EncodeToString(meta.InfoHash()))
+	assert.Equal(t, "Ubuntu CD releases.ubuntu.com", meta.Comment)
+	assert.Equal(t, "mktorrent 1.1", meta.CreatedBy)
+	assert.Equal(t, "2022-08-11T11:01:01Z", meta.CreationDate.UTC().Format(time.RFC3339))
+}
+
+func TestParseMetainfo_OptionalFields(t *testing.T) {
+	info := "4:infod6:lengthi4e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("h", 20) + "6:source3:srce"
+	raw := "d7:comment2:hi10:created by4:test13:creation datei1000e8:encoding5:UTF-8" + info +
+		"5:nodesll9:127.0.0.1i6881eel3:::1i1eel7:no portel4:hosti0eeee"
+	meta, err := ParseMetainfo(strings.NewReader(raw))
+	if !assert.NoError(t, err) {
+		return
+	}
+	// trackerless torrents bootstrap from DHT nodes instead
+	assert.Nil(t, meta.TrackerURL)
+	assert.Equal(t, []string{"127.0.0.1:6881", "[::1]:1"}, meta.Nodes)
+	assert.Equal(t, "hi", meta.Comment)
+	assert.Equal(t, "test", meta.CreatedBy)
+	assert.Equal(t, int64(1000), meta.CreationDate.Unix())
+	assert.Equal(t, "UTF-8", meta.Encoding)
+	assert.Equal(t, "src", meta.Source)
+
+	_, err = ParseMetainfo(strings.NewReader("d" + info + "e"))
+	assert.ErrorContains(t, err, "missing announce url")
+}
+
+func TestParseMetainfo_MultiFileInfoHash(t *testing.T) {
+	// info keys are out of order, so the infohash is only correct if computed from the original bytes
+	info := "d4:name3:dir12:piece lengthi4e5:filesld6:lengthi3e4:pathl1:aeed6:lengthi4e4:pathl1:b1:ceee" +
+		"6:pieces40:" + strings.Repeat("h", 40) + "e"
+	raw := "d8:announce14:http://tracker4:info" + info + "e"
+
+	meta, err := ParseMetainfo(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), meta.TotalSizeBytes)
+	assert.Len(t, meta.Files, 2)
+	assert.Equal(t, []string{"b", "c"}, meta.Files[1].Path)
+	hash := sha1.Sum([]byte(info))
+	assert.Equal(t, hash[:], meta.InfoHash())
+}
+
+func TestParseMetainfo_ErrorPath(t *testing.T) {
+	raw := "d8:announce14:http://tracker4:infod5:filesld6:lengthi3e4:pathl1:aeed6:length1:x4:pathl1:beee4:name3:dir" +
+		"12:piece lengthi4e6:pieces20:" + strings.Repeat("h", 20) + "ee"
+	_, err := ParseMetainfo(strings.NewReader(raw))
+	var typeErr *bencoding.UnmarshalTypeError
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, "info.files[1].length", typeErr.Field)
+		assert.Equal(t, int64(76), typeErr.Offset)
+	}
+}
+
+func TestParseMetainfo_EmptyPath(t *testing.T) {
+	raw := func(file string) string {
+		return "d8:announce14:http://tracker4:infod5:filesl" + file + "e4:name3:dir" +
+			"12:piece lengthi4e6:pieces20:" + strings.Repeat("h", 20) + "ee"
+	}
+	_, err := ParseMetainfo(strings.NewReader(raw("d6:lengthi3e4:pathlee")))
+	assert.ErrorContains(t, err, "empty file path")
+
+	// an empty path.utf-8 falls back to path
+	meta, err := ParseMetainfo(strings.NewReader(raw("d6:lengthi3e4:pathl1:ae10:path.utf-8lee")))
+	if assert.NoError(t, err) && assert.Len(t, meta.Files, 1) {
+		assert.Equal(t, []string{"a"}, meta.Files[0].Path)
+	}
+}
+
+func TestParseMetainfo_Limits(t *testing.T) {
+	_, err := ParseMetainfo(strings.NewReader("d8:announce9223372036854775807:xe"))
+	assert.Error(t, err)
+
+	_, err = ParseMetainfo(strings.NewReader("d7:unknown" + strings.Repeat("l", 1000) + strings.Repeat("e", 1000) + "e"))
+	var limitErr *bencoding.LimitError
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, "MaxDepth", limitErr.Limit)
+	}
+}
+
+func TestParseMetainfo_LargeFiles(t *testing.T) {
+	// 3 TiB across two files, well past what fits in a 32-bit int
+	const pieceLength = 1 << 24
+	const fileLength = 3 << 39
+	numPieces := (2*fileLength + pieceLength - 1) / pieceLength
+	raw := "d8:announce14:http://tracker4:infod5:filesld6:lengthi1649267441664e4:pathl1:aeed6:lengthi1649267441664e4:pathl1:beee" +
+		"4:name3:dir12:piece lengthi16777216e6:pieces" + strconv.Itoa(numPieces*sha1.Size) + ":" +
+		strings.Repeat("h", numPieces*sha1.Size) + "ee"
+
+	meta, err := ParseMetainfo(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*fileLength), meta.TotalSizeBytes)
+	assert.Equal(t, int64(fileLength), meta.Files[1].SizeBytes)
+	assert.Len(t, meta.Hashes, numPieces)
+}
+
+func TestParseMetainfo_AnnounceList(t *testing.T) {
+	info := "d6:lengthi4e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("h", 20) + "e"
+	raw := "d8:announce8:http://a13:announce-listll8:http://a8:http://bel0:el8:http://cee4:info" + info + "e"
+
+	meta, err := ParseMetainfo(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://a", meta.TrackerURL.String())
+	tiers := meta.AnnounceTiers()
+	if assert.Len(t, tiers, 2) {
+		assert.Len(t, tiers[0], 2)
+		assert.Equal(t, "http://c", tiers[1][0].String())
+	}
+
+	// announce is optional when announce-list is present
+	raw = "d13:announce-listll8:http://bee4:info" + info + "e"
+	meta, err = ParseMetainfo(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://b", meta.TrackerURL.String())
+}
+
+// v2TestFile is a file in a torrent built by buildV2Torrent.
+type v2TestFile struct {
+	path []string
+	data []byte
+}
+
+// buildV2Torrent returns a v2 torrent, or a hybrid torrent with v1 padding files, describing files. It also returns
+// the contents of each piece.
+func buildV2Torrent(t *testing.T, files []v2TestFile, pieceLength int, hybrid bool) ([]byte, [][]byte) {
+	t.Helper()
+	tree := map[string]any{}
+	layers := map[string]any{}
+	var v1Files []any
+	var pieces [][]byte
+	var aligned []byte
+	for i, f := range files {
+		var root [sha256.Size]byte
+		numPieces := (len(f.data) + pieceLength - 1) / pieceLength
+		if numPieces == 1 {
+			root = merkleDataRoot(f.data, nextPowerOfTwo((len(f.data)+merkleBlockSize-1)/merkleBlockSize))
+		} else if numPieces > 1 {
+			var hashes [][sha256.Size]byte
+			var layer []byte
+			for p := 0; p < numPieces; p++ {
+				end := (p + 1) * pieceLength
+				if end > len(f.data) {
+					end = len(f.data)
+				}
+				h := merkleDataRoot(f.data[p*pieceLength:end], pieceLength/merkleBlockSize)
+				hashes = append(hashes, h)
+				layer = append(layer, h[:]...)
+			}
+			root = merkleRoot(hashes, nextPowerOfTwo(numPieces), merklePadRoot(pieceLength/merkleBlockSize))
+			layers[string(root[:])] = layer
+		}
+		for p := 0; p < numPieces; p++ {
+			end := (p + 1) * pieceLength
+			if end > len(f.data) {
+				end = len(f.data)
+			}
+			pieces = append(pieces, f.data[p*pieceLength:end])
+		}
+
+		node := tree
+		for _, name := range f.path[:len(f.path)-1] {
+			if node[name] == nil {
+				node[name] = map[string]any{}
+			}
+			node = node[name].(map[string]any)
+		}
+		entry := map[string]any{"length": len(f.data)}
+		if len(f.data) > 0 {
+			entry["pieces root"] = root[:]
+		}
+		node[f.path[len(f.path)-1]] = map[string]any{"": entry}
+
+		aligned = append(aligned, f.data...)
+		v1Files = append(v1Files, map[string]any{"length": len(f.data), "path": f.path})
+		if pad := (pieceLength - len(f.data)%pieceLength) % pieceLength; pad > 0 && i < len(files)-1 {
+			aligned = append(aligned, make([]byte, pad)...)
+			pieces[len(pieces)-1] = append(append([]byte(nil), pieces[len(pieces)-1]...), make([]byte, pad)...)
+			v1Files = append(v1Files, map[string]any{"length": pad, "path": []string{".pad", strconv.Itoa(pad)}, "attr": "p"})
+		}
+	}
+
+	info := map[string]any{
+		"name":         "dir",
+		"piece length": pieceLength,
+		"meta version": 2,
+		"file tree":    tree,
+	}
+	if hybrid {
+		var hashes []byte
+		for off := 0; off < len(aligned); off += pieceLength {
+			end := off + pieceLength
+			if end > len(aligned) {
+				end = len(aligned)
+			}
+			h := sha1.Sum(aligned[off:end])
+			hashes = append(hashes, h[:]...)
+		}
+		info["files"] = v1Files
+		info["pieces"] = hashes
+	} else {
+		// without padding the last piece of each file is short
+		for i := range pieces {
+			pieces[i] = bytes.TrimRight(pieces[i], "\x00")
+		}
+	}
+	raw, err := bencoding.Marshal(map[string]any{
+		"announce":     "http://tracker",
+		"info":         info,
+		"piece layers": layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw, pieces
+}
+
+func TestParseMetainfo_DeepFileTree(t *testing.T) {
+	path := make([]string, 200)
+	for i := range path {
+		path[i] = strconv.Itoa(i)
+	}
+	raw, _ := buildV2Torrent(t, []v2TestFile{{path: path, data: []byte("deep")}}, 16<<10, false)
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	if assert.NoError(t, err) && assert.Len(t, meta.FileTree, 1) {
+		assert.Equal(t, path, meta.FileTree[0].Path)
+	}
+
+	raw = []byte("d8:announce14:http://tracker4:infod9:file treed1:ai1ee12:meta versioni2e4:name3:dir12:piece lengthi16384eee")
+	_, err = ParseMetainfo(bytes.NewReader(raw))
+	assert.ErrorContains(t, err, "invalid file tree entry")
+}
+
+func fillPiece(p *Piece, data []byte) {
+	for _, b := range p.MissingBlocks() {
+		p.AddBlockPayload(b, data[b.BeginOffset:b.BeginOffset+b.Length])
+	}
+}
+
+func v2TestFiles() []v2TestFile {
+	big := make([]byte, 40000)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	return []v2TestFile{
+		{path: []string{"a", "big"}, data: big},
+		{path: []string{"b"}, data: []byte(strings.Repeat("b", 100))},
+		{path: []string{"empty"}, data: nil},
+	}
+}
+
+func TestParseMetainfo_V2(t *testing.T) {
+	raw, pieces := buildV2Torrent(t, v2TestFiles(), 32<<10, false)
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, meta.MetaVersion)
+	assert.Nil(t, meta.Hashes)
+	assert.Equal(t, int64(40100), meta.TotalSizeBytes)
+	assert.Equal(t, 3, meta.NumPieces())
+	var paths [][]string
+	for _, f := range meta.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, [][]string{{"a", "big"}, {"b"}, {"empty"}}, paths)
+	assert.Len(t, meta.PieceLayers, 1)
+
+	var rm struct {
+		Info bencoding.RawMessage `bencode:"info"`
+	}
+	assert.NoError(t, bencoding.Unmarshal(raw, &rm))
+	hash := sha256.Sum256(rm.Info)
+	assert.Equal(t, hash[:], meta.InfoHashV2())
+	assert.Equal(t, hash[:sha1.Size], meta.InfoHash())
+	assert.Equal(t, [][]byte{hash[:sha1.Size]}, meta.SwarmInfoHashes())
+
+	for i, data := range pieces {
+		p := meta.newPiece(i)
+		assert.Nil(t, p.Hash)
+		if assert.Equal(t, uint32(len(data)), p.Size, "piece %d", i) {
+			fillPiece(p, data)
+			assert.True(t, p.Valid(), "piece %d", i)
+		}
+	}
+
+	p := meta.newPiece(1)
+	corrupt := append([]byte(nil), pieces[1]...)
+	corrupt[0] ^= 1
+	fillPiece(p, corrupt)
+	assert.False(t, p.Valid())
+}
+
+func TestParseMetainfo_Hybrid(t *testing.T) {
+	raw, pieces := buildV2Torrent(t, v2TestFiles(), 32<<10, true)
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, meta.MetaVersion)
+	assert.Len(t, meta.Hashes, 3)
+	assert.Len(t, meta.FileTree, 3)
+	swarms := meta.SwarmInfoHashes()
+	if assert.Len(t, swarms, 2) {
+		assert.Equal(t, meta.InfoHash(), swarms[0])
+		assert.Equal(t, meta.InfoHashV2()[:sha1.Size], swarms[1])
+	}
+
+	for i, data := range pieces {
+		p := meta.newPiece(i)
+		assert.NotNil(t, p.Hash)
+		assert.NotNil(t, p.HashV2)
+		if assert.Equal(t, uint32(len(data)), p.Size, "piece %d", i) {
+			fillPiece(p, data)
+			assert.True(t, p.Valid(), "piece %d", i)
+		}
+	}
+}
+
+func TestParseMetainfo_V2Invalid(t *testing.T) {
+	raw, _ := buildV2Torrent(t, v2TestFiles(), 32<<10, false)
+	// corrupt the only piece layer
+	i := bytes.Index(raw, []byte("12:piece layers")) + len("12:piece layersd32:") + sha256.Size + len("64:")
+	raw[i] ^= 1
+	_, err := ParseMetainfo(bytes.NewReader(raw))
+	assert.ErrorContains(t, err, "does not match its pieces root")
+
+	raw, _ = buildV2Torrent(t, v2TestFiles(), 32<<10, false)
+	raw = bytes.Replace(raw, []byte("12:piece lengthi32768e"), []byte("12:piece lengthi20000e"), 1)
+	_, err = ParseMetainfo(bytes.NewReader(raw))
+	assert.ErrorContains(t, err, "power of two")
+}
+
+func TestMerkleRoot(t *testing.T) {
+	block := bytes.Repeat([]byte{1}, merkleBlockSize)
+	leaf := sha256.Sum256(block)
+	assert.Equal(t, leaf, merkleDataRoot(block, 1))
+	assert.Equal(t, hashPair(leaf, [sha256.Size]byte{}), merkleDataRoot(block, 2))
+	assert.Equal(t, hashPair(hashPair(leaf, leaf), hashPair(leaf, [sha256.Size]byte{})), merkleDataRoot(bytes.Repeat(block, 3), 4))
+	zero := [sha256.Size]byte{}
+	assert.Equal(t, hashPair(hashPair(zero, zero), hashPair(zero, zero)), merklePadRoot(4))
+}
+
+func TestParseMetainfo_URLList(t *testing.T) {
+	info := "4:infod6:lengthi4e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("h", 20) + "e"
+	meta, err := ParseMetainfo(strings.NewReader("d8:announce8:http://a" + info + "8:url-list14:http://mirror/e"))
+	assert.NoError(t, err)
+	if assert.Len(t, meta.WebSeeds, 1) {
+		assert.Equal(t, "http://mirror/", meta.WebSeeds[0].String())
+	}
+
+	meta, err = ParseMetainfo(strings.NewReader("d8:announce8:http://a" + info + "8:url-listl8:http://b0:8:http://cee"))
+	assert.NoError(t, err)
+	assert.Len(t, meta.WebSeeds, 2)
+}
+
+// smallFilesTorrent returns a v1 torrent with 8 byte pieces over many small files. Files 0, 2, 3, 4 and the start of
+// 5 share piece 0, the rest of file 5 fills piece 1 and file 6 is the short last piece. Files 1 and 7 are empty.
+func smallFilesTorrent() Metainfo {
+	meta := Metainfo{Name: "dir", PieceSizeBytes: 8, TotalSizeBytes: 18, Hashes: make([][20]byte, 3)}
+	for _, size := range []int64{3, 0, 1, 1, 1, 10, 2, 0} {
+		meta.Files = append(meta.Files, File{SizeBytes: size})
+	}
+	return meta
+}
+
+func TestMetainfo_FileSpans(t *testing.T) {
+	meta := smallFilesTorrent()
+	assert.Equal(t, []FileSpan{{0, 0, 3}, {2, 0, 1}, {3, 0, 1}, {4, 0, 1}, {5, 0, 2}}, meta.FileSpans(0))
+	assert.Equal(t, []FileSpan{{5, 2, 8}}, meta.FileSpans(1))
+	assert.Equal(t, []FileSpan{{6, 0, 2}}, meta.FileSpans(2))
+	assert.Nil(t, meta.FileSpans(3))
+	assert.Nil(t, meta.FileSpans(-1))
+
+	for i := 0; i < meta.NumPieces(); i++ {
+		var total int64
+		for _, span := range meta.FileSpans(i) {
+			total += span.Length
+		}
+		assert.Equal(t, meta.PieceSize(i), total, "piece %d", i)
+	}
+
+	single := Metainfo{Name: "a", PieceSizeBytes: 8, TotalSizeBytes: 10, Hashes: make([][20]byte, 2)}
+	assert.Equal(t, []FileSpan{{0, 8, 2}}, single.FileSpans(1))
+}
+
+func TestMetainfo_PieceSize(t *testing.T) {
+	meta := smallFilesTorrent()
+	assert.Equal(t, int64(8), meta.PieceSize(0))
+	assert.Equal(t, int64(8), meta.PieceSize(1))
+	assert.Equal(t, int64(2), meta.PieceSize(2))
+	assert.Equal(t, int64(0), meta.PieceSize(3))
+	assert.Equal(t, uint32(2), meta.newPiece(2).Size)
+}
+
+func TestMetainfo_PiecesForFile(t *testing.T) {
+	meta := smallFilesTorrent()
+	for idx, want := range [][2]int{{0, 1}, {0, 0}, {0, 1}, {0, 1}, {0, 1}, {0, 2}, {2, 3}, {0, 0}, {0, 0}} {
+		begin, end := meta.PiecesForFile(idx)
+		if want[0] == want[1] {
+			assert.Equal(t, begin, end, "file %d", idx)
+			continue
+		}
+		assert.Equal(t, want, [2]int{begin, end}, "file %d", idx)
+	}
+}
+
+func TestMetainfo_PiecesForRange(t *testing.T) {
+	meta := smallFilesTorrent()
+	for _, test := range []struct {
+		offset, length int64
+		want           [2]int
+	}{
+		{0, 18, [2]int{0, 3}},
+		{0, 8, [2]int{0, 1}},
+		{7, 2, [2]int{0, 2}},
+		{8, 1, [2]int{1, 2}},
+		{17, 100, [2]int{2, 3}},
+		{-4, 6, [2]int{0, 1}},
+	} {
+		begin, end := meta.PiecesForRange(test.offset, test.length)
+		assert.Equal(t, test.want, [2]int{begin, end}, "range %d+%d", test.offset, test.length)
+	}
+	for _, empty := range [][2]int64{{3, 0}, {18, 5}, {-5, 5}} {
+		begin, end := meta.PiecesForRange(empty[0], empty[1])
+		assert.Equal(t, begin, end, "range %d+%d", empty[0], empty[1])
+	}
+}
+
+func TestMetainfo_PieceMappingV2(t *testing.T) {
+	// v2 pieces never span files: 20 bytes of "a" take 2 pieces, the empty file none, and 5 bytes of "c" one
+	files := []File{{SizeBytes: 20, Path: []string{"a"}}, {SizeBytes: 0, Path: []string{"b"}}, {SizeBytes: 5, Path: []string{"c"}}}
+	meta := Metainfo{Name: "dir", MetaVersion: 2, PieceSizeBytes: 16, TotalSizeBytes: 25, Files: files, FileTree: files}
+	assert.Equal(t, 3, meta.NumPieces())
+	assert.Equal(t, []FileSpan{{0, 16, 4}}, meta.FileSpans(1))
+	assert.Equal(t, []FileSpan{{2, 0, 5}}, meta.FileSpans(2))
+	assert.Equal(t, int64(4), meta.PieceSize(1))
+	assert.Equal(t, int64(5), meta.PieceSize(2))
+
+	begin, end := meta.PiecesForFile(0)
+	assert.Equal(t, [2]int{0, 2}, [2]int{begin, end})
+	begin, end = meta.PiecesForFile(1)
+	assert.Equal(t, begin, end)
+	begin, end = meta.PiecesForFile(2)
+	assert.Equal(t, [2]int{2, 3}, [2]int{begin, end})
+
+	// bytes 18-21 are the end of "a" and the start of "c"
+	begin, end = meta.PiecesForRange(18, 4)
+	assert.Equal(t, [2]int{1, 3}, [2]int{begin, end})
+	begin, end = meta.PiecesForRange(3, 1)
+	assert.Equal(t, [2]int{0, 1}, [2]int{begin, end})
 }
@@ -10,16 +10,22 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
 
 type PeerInfo struct {
-	PeerID PeerID
+	PeerID PeerID // zero if not known in advance, as with compact peer lists
 	IP     net.IP
 	Port   int
 }
 
+// Addr returns the peer's address as host:port.
+func (i PeerInfo) Addr() string {
+	return net.JoinHostPort(i.IP.String(), strconv.Itoa(i.Port))
+}
+
 type Peer struct {
 	self     PeerID
 	infohash []byte
@@ -30,6 +36,8 @@ type Peer struct {
 
 	subscriber chan<- Message
 
+	allowExtended bool // whether to accept extended messages, which private torrents must ignore as they may carry peer exchange
+
 	peerHas Bitfield // peer's Bitfield
 
 	// Local's interest in remote peer
@@ -61,10 +69,7 @@ func (p *Peer) Initialize(ctx context.Context) (err error) {
 	dialer := net.Dialer{
 		KeepAlive: 2 * time.Minute,
 	}
-	p.conn, err = dialer.DialContext(ctx, "tcp", (&net.TCPAddr{
-		IP:   p.info.IP,
-		Port: p.info.Port,
-	}).String())
+	p.conn, err = dialer.DialContext(ctx, "tcp", p.info.Addr())
 
 	p.conn = internal.NewEavesdropper(p.conn)
 
@@ -100,7 +105,14 @@ func (p *Peer) Initialize(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
-	if p.info.PeerID.String() != string(resp) {
+	remote := PeerIDFromString(string(resp))
+	if remote == p.self {
+		return errors.New("connected to self")
+	}
+	if p.info.PeerID == (PeerID{}) {
+		// the peer was found without its id, so accept whichever it presents
+		p.info.PeerID = remote
+	} else if p.info.PeerID != remote {
 		return fmt.Errorf("%w. got %s", errors.New("mismatched peer id"), p.info.PeerID.String())
 	}
 
@@ -140,6 +152,11 @@ const (
 	CancelMessage
 )
 
+// ExtendedMessage carries messages of protocol extensions, identified by the first byte of the payload.
+//
+// See: https://www.bittorrent.org/beps/bep_0010.html
+const ExtendedMessage MessageType = 20
+
 func (p *Peer) Run() error {
 	go func() { p.keepAliveLoop() }()
 	go func() {
@@ -191,6 +208,13 @@ func (p *Peer) Run() error {
 			if p.peerHas.Empty() {
 				p.peerHas = payload // TODO validate
 			}
+		case ExtendedMessage:
+			// Extended message IDs are negotiated per connection, and we never negotiate any, so there is no telling
+			// which carry peer exchange (BEP-11). Private torrents drop them all.
+			if !p.allowExtended {
+				log.Println("Ignoring extended message for private torrent")
+				continue
+			}
 		}
 
 		if p.subscriber != nil {
@@ -0,0 +1,140 @@
+package bytedribble
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// PeerSourceKind identifies how a PeerSource finds peers.
+type PeerSourceKind int
+
+const (
+	TrackerSource        PeerSourceKind = iota // the torrent's own trackers
+	DHTSource                                  // the distributed hash table (BEP-5)
+	LocalDiscoverySource                       // local service discovery (BEP-14)
+	PeerExchangeSource                         // peer exchange over the extension protocol (BEP-11)
+)
+
+func (k PeerSourceKind) String() string {
+	switch k {
+	case TrackerSource:
+		return "tracker"
+	case DHTSource:
+		return "DHT"
+	case LocalDiscoverySource:
+		return "local discovery"
+	case PeerExchangeSource:
+		return "peer exchange"
+	}
+	return "PeerSourceKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// PeerSource finds peers sharing a torrent. Finding peers may announce the local peer to the source.
+type PeerSource interface {
+	Kind() PeerSourceKind
+	FindPeers(ctx context.Context) ([]PeerInfo, error)
+}
+
+// TrackerPeerSource is a PeerSource that finds peers through trackers, and can tell which.
+type TrackerPeerSource interface {
+	PeerSource
+	TrackerURLs() []*url.URL
+}
+
+// ErrPrivateTorrent is returned when attempting to use a peer source that a private torrent does not allow.
+var ErrPrivateTorrent = errors.New("private torrents may only use their own trackers")
+
+// AllowsPeerSource reports whether peers may be found, and the local peer announced, with a source of the given kind.
+// Private torrents only allow their own trackers.
+//
+// See: https://www.bittorrent.org/beps/bep_0027.html
+func (m Metainfo) AllowsPeerSource(kind PeerSourceKind) bool {
+	return !m.Private || kind == TrackerSource
+}
+
+// allowsSource reports whether peers may be found, and the local peer announced, with src. For private torrents, src
+// must be a TrackerPeerSource using only the torrent's own trackers, whatever kind it claims to be.
+func (m Metainfo) allowsSource(src PeerSource) bool {
+	if !m.AllowsPeerSource(src.Kind()) {
+		return false
+	}
+	if !m.Private {
+		return true
+	}
+	ts, ok := src.(TrackerPeerSource)
+	if !ok {
+		return false
+	}
+	own := make(map[string]bool)
+	for _, tier := range m.AnnounceTiers() {
+		for _, u := range tier {
+			own[u.String()] = true
+		}
+	}
+	urls := ts.TrackerURLs()
+	for _, u := range urls {
+		if !own[u.String()] {
+			return false
+		}
+	}
+	return len(urls) > 0
+}
+
+// PeerSources combines the peer sources of a torrent, refusing those the torrent does not allow.
+type PeerSources struct {
+	target Metainfo
+
+	mu      sync.Mutex
+	sources []PeerSource
+}
+
+func NewPeerSources(target Metainfo) *PeerSources {
+	return &PeerSources{target: target}
+}
+
+// Add adds a peer source. It returns ErrPrivateTorrent, and the source is never consulted, if the torrent does not
+// allow it.
+func (s *PeerSources) Add(src PeerSource) error {
+	if !s.target.allowsSource(src) {
+		return ErrPrivateTorrent
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = append(s.sources, src)
+	return nil
+}
+
+// FindPeers returns the distinct peers found by all sources. It only fails if every source fails.
+func (s *PeerSources) FindPeers(ctx context.Context) ([]PeerInfo, error) {
+	s.mu.Lock()
+	sources := append([]PeerSource(nil), s.sources...)
+	s.mu.Unlock()
+
+	var peers []PeerInfo
+	var firstErr error
+	failed := 0
+	seen := make(map[string]bool)
+	for _, src := range sources {
+		found, err := src.FindPeers(ctx)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		for _, p := range found {
+			if addr := p.Addr(); !seen[addr] {
+				seen[addr] = true
+				peers = append(peers, p)
+			}
+		}
+	}
+	if failed > 0 && failed == len(sources) {
+		return nil, firstErr
+	}
+	return peers, nil
+}
@@ -0,0 +1,163 @@
+package bytedribble
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakePeerSource struct {
+	kind     PeerSourceKind
+	peers    []PeerInfo
+	calls    int
+	trackers []*url.URL
+}
+
+func (s *fakePeerSource) Kind() PeerSourceKind {
+	return s.kind
+}
+
+func (s *fakePeerSource) TrackerURLs() []*url.URL {
+	return s.trackers
+}
+
+func (s *fakePeerSource) FindPeers(ctx context.Context) ([]PeerInfo, error) {
+	s.calls++
+	return s.peers, nil
+}
+
+// testTrackerURL is the tracker of the torrents used with fake peer sources.
+var testTrackerURL, _ = url.Parse("http://tracker/announce")
+
+func newFakePeerSources() []*fakePeerSource {
+	var sources []*fakePeerSource
+	for i, kind := range []PeerSourceKind{TrackerSource, DHTSource, LocalDiscoverySource, PeerExchangeSource} {
+		sources = append(sources, &fakePeerSource{
+			kind:     kind,
+			trackers: []*url.URL{testTrackerURL},
+			peers: []PeerInfo{
+				{IP: net.IPv4(10, 0, 0, 1), Port: 6881}, // known to every source
+				{IP: net.IPv4(10, 0, 1, byte(i)), Port: 6881},
+			},
+		})
+	}
+	return sources
+}
+
+func TestPeerSources_Private(t *testing.T) {
+	sources := NewPeerSources(Metainfo{Private: true, TrackerURL: testTrackerURL})
+	fakes := newFakePeerSources()
+	for _, src := range fakes {
+		err := sources.Add(src)
+		if src.kind == TrackerSource {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, ErrPrivateTorrent, src.kind.String())
+		}
+	}
+
+	peers, err := sources.FindPeers(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, peers, 2)
+	for _, src := range fakes {
+		if src.kind == TrackerSource {
+			assert.Equal(t, 1, src.calls)
+		} else {
+			assert.Zero(t, src.calls, "%s was consulted for a private torrent", src.kind)
+		}
+	}
+}
+
+func TestPeerSources_PrivateTrackers(t *testing.T) {
+	sources := NewPeerSources(Metainfo{Private: true, TrackerURL: testTrackerURL})
+	other, _ := url.Parse("http://other/announce")
+	// tracker sources must name only the torrent's own trackers
+	assert.ErrorIs(t, sources.Add(&fakePeerSource{kind: TrackerSource}), ErrPrivateTorrent)
+	assert.ErrorIs(t, sources.Add(&fakePeerSource{kind: TrackerSource, trackers: []*url.URL{testTrackerURL, other}}), ErrPrivateTorrent)
+	assert.ErrorIs(t, sources.Add(struct{ PeerSource }{&fakePeerSource{kind: TrackerSource}}), ErrPrivateTorrent)
+	assert.NoError(t, sources.Add(&fakePeerSource{kind: TrackerSource, trackers: []*url.URL{testTrackerURL}}))
+
+	tc := NewTrackerClient(nil, Metainfo{TrackerURL: testTrackerURL, RawInfo: []byte("de")}, PeerInfo{}, FakeMetrics{})
+	assert.NoError(t, sources.Add(tc))
+	tc = NewTrackerClient(nil, Metainfo{TrackerURL: other, RawInfo: []byte("de")}, PeerInfo{}, FakeMetrics{})
+	assert.ErrorIs(t, sources.Add(tc), ErrPrivateTorrent)
+}
+
+func TestPeerSources_Public(t *testing.T) {
+	sources := NewPeerSources(Metainfo{})
+	fakes := newFakePeerSources()
+	for _, src := range fakes {
+		assert.NoError(t, sources.Add(src))
+	}
+
+	peers, err := sources.FindPeers(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, peers, 5) // one shared peer and one per source
+	for _, src := range fakes {
+		assert.Equal(t, 1, src.calls)
+	}
+}
+
+func TestDownloader_PrivatePeerSources(t *testing.T) {
+	meta := Metainfo{Private: true, TrackerURL: testTrackerURL, RawInfo: []byte("de")}
+	d := NewDownloader(meta, PeerInfo{}, nil)
+	assert.ErrorIs(t, d.AddPeerSource(&fakePeerSource{kind: DHTSource}), ErrPrivateTorrent)
+	assert.NoError(t, d.AddPeerSource(&fakePeerSource{kind: TrackerSource, trackers: []*url.URL{testTrackerURL}}))
+}
+
+func TestPeer_PrivateExtendedMessages(t *testing.T) {
+	// extended message IDs are negotiated per connection, so peer exchange may arrive under any of them
+	pex := []byte{0, 0, 0, 4, byte(ExtendedMessage), 1, 'd', 'e', 0, 0, 0, 4, byte(ExtendedMessage), 7, 'd', 'e'}
+	have := []byte{0, 0, 0, 5, byte(HaveMessage), 0, 0, 0, 3}
+
+	for _, allow := range []bool{false, true} {
+		local, remote := net.Pipe()
+		p := NewPeer(PeerInfo{}, PeerID{}, nil, 8)
+		p.conn = local
+		p.allowExtended = allow
+		messages := make(chan Message, 10)
+		assert.NoError(t, p.Subscribe(messages))
+		go func() { _ = p.Run() }()
+
+		_, err := remote.Write(append(pex, have...))
+		assert.NoError(t, err)
+		select {
+		case msg := <-messages:
+			if allow {
+				assert.Equal(t, ExtendedMessage, msg.Type)
+			} else {
+				assert.Equal(t, HaveMessage, msg.Type, "peer exchange message should have been dropped")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+		p.Close()
+		_ = remote.Close()
+	}
+}
+
+func TestParseMetainfo_Private(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []bool{false, true} {
+		raw, err := MetainfoBuilder{Path: path, AnnounceURLs: []string{"http://tracker"}, Private: private}.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ParseMetainfo(bytes.NewReader(raw))
+		assert.NoError(t, err)
+		assert.Equal(t, private, meta.Private)
+		assert.True(t, meta.AllowsPeerSource(TrackerSource))
+		for _, kind := range []PeerSourceKind{DHTSource, LocalDiscoverySource, PeerExchangeSource} {
+			assert.Equal(t, !private, meta.AllowsPeerSource(kind), kind.String())
+		}
+	}
+}
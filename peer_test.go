@@ -0,0 +1,73 @@
+package bytedribble
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+)
+
+// handshakeServer accepts one connection and completes the handshake as the peer with the given id.
+func handshakeServer(t *testing.T, id PeerID) PeerInfo {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, len(defaultHeader)+20)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		_, _ = conn.Write(header)
+		if _, err := io.ReadFull(conn, make([]byte, peerIDLen)); err != nil {
+			return
+		}
+		_, _ = conn.Write(id[:])
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return PeerInfo{IP: addr.IP, Port: addr.Port}
+}
+
+func TestPeer_Initialize(t *testing.T) {
+	self := PeerIDFromString("-DR0001-000000000000")
+	remote := PeerIDFromString("-XX0001-111111111111")
+	infoHash := make([]byte, 20)
+
+	tests := map[string]struct {
+		known    PeerID
+		presents PeerID
+		wantErr  string
+	}{
+		"unknown id":    {presents: remote},
+		"known id":      {known: remote, presents: remote},
+		"mismatched id": {known: PeerIDFromString("-YY0001-222222222222"), presents: remote, wantErr: "mismatched peer id"},
+		"self":          {presents: self, wantErr: "connected to self"},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			info := handshakeServer(t, test.presents)
+			info.PeerID = test.known
+			p := NewPeer(info, self, infoHash, 8)
+			err := p.Initialize(context.Background())
+			if p.conn != nil {
+				defer p.conn.Close()
+			}
+			if test.wantErr != "" {
+				assert.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, remote, p.info.PeerID)
+		})
+	}
+}
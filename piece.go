@@ -9,7 +9,8 @@ type Piece struct {
 	Index     uint32
 	Size      uint32
 	BlockSize uint32
-	Hash      [sha1.Size]byte
+	Hash      *[sha1.Size]byte // SHA-1 of the piece, for v1 and hybrid torrents
+	HashV2    *MerkleHash      // root of the piece's merkle tree, for v2 and hybrid torrents
 
 	blocks  [][]byte // blocks are views into payload
 	payload []byte
@@ -71,7 +72,13 @@ func (p *Piece) Valid() bool {
 	if len(p.MissingBlocks()) != 0 {
 		return false
 	}
-	return sha1.Sum(p.payload) == p.Hash
+	if p.Hash == nil && p.HashV2 == nil {
+		return false
+	}
+	if p.Hash != nil && sha1.Sum(p.payload) != *p.Hash {
+		return false
+	}
+	return p.HashV2 == nil || p.HashV2.Verify(p.payload)
 }
 
 func (p *Piece) Payload() []byte {
@@ -79,7 +86,13 @@ func (p *Piece) Payload() []byte {
 }
 
 func (p *Piece) String() string {
-	return fmt.Sprintf("{Index: %d; Size: %d; Hash: %v}", p.Index, p.Size, p.Hash)
+	var hash []byte
+	if p.Hash != nil {
+		hash = p.Hash[:]
+	} else if p.HashV2 != nil {
+		hash = p.HashV2.Root[:]
+	}
+	return fmt.Sprintf("{Index: %d; Size: %d; Hash: %x}", p.Index, p.Size, hash)
 }
 
 func (p *Piece) Reset() {
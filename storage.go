@@ -0,0 +1,148 @@
+package bytedribble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStorage writes a torrent's pieces into its files beneath a directory. A single-file torrent is stored in a file
+// named after the torrent, and a multi-file torrent in a directory named after the torrent.
+type FileStorage struct {
+	dir    string
+	target Metainfo
+	paths  []string // see Metainfo.FilePaths
+
+	mu    sync.Mutex
+	files map[int]*os.File
+}
+
+// NewFileStorage returns storage for the torrent beneath dir. It fails if the torrent's file paths are unsafe.
+func NewFileStorage(dir string, target Metainfo) (*FileStorage, error) {
+	paths, err := target.FilePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{
+		dir:    dir,
+		target: target,
+		paths:  paths,
+		files:  make(map[int]*os.File),
+	}, nil
+}
+
+// stored reports whether the file at index idx holds piece data on disk.
+func (s *FileStorage) stored(idx int) bool {
+	if s.target.Files == nil {
+		return true
+	}
+	f := s.target.Files[idx]
+	return !f.IsPadding() && !f.IsSymlink()
+}
+
+// WritePiece writes a complete piece to the files it spans. Data belonging to padding files is discarded.
+func (s *FileStorage) WritePiece(p *Piece) error {
+	data := p.Payload()
+	var pos int64
+	for _, span := range s.target.FileSpans(int(p.Index)) {
+		if pos+span.Length > int64(len(data)) {
+			return fmt.Errorf("piece %d is smaller than its files", p.Index)
+		}
+		chunk := data[pos : pos+span.Length]
+		pos += span.Length
+		if !s.stored(span.FileIndex) {
+			continue
+		}
+		f, err := s.open(span.FileIndex)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(chunk, span.FileOffset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStorage) open(idx int) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[idx]; ok {
+		return f, nil
+	}
+	path := s.paths[idx]
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.files[idx] = f
+	return f, nil
+}
+
+// Finish closes the files written so far and materialises the parts of the torrent that hold no piece data: empty
+// files are created, symlinks are linked to their targets and executable files are marked executable. Hidden files
+// need no special treatment, since they are hidden by their names on the platforms we support.
+func (s *FileStorage) Finish() error {
+	s.mu.Lock()
+	var errs []error
+	for idx, f := range s.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(s.files, idx)
+	}
+	s.mu.Unlock()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	for idx, file := range s.target.Files {
+		path := s.paths[idx]
+		switch {
+		case file.IsPadding():
+			continue
+		case file.IsSymlink():
+			if err := s.symlink(path, file.SymlinkPath); err != nil {
+				return err
+			}
+			continue
+		case file.SizeBytes == 0:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, nil, 0o644); err != nil {
+				return err
+			}
+		}
+		if file.IsExecutable() {
+			if err := os.Chmod(path, 0o755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// symlink creates a relative symlink at path to the file at target, which is relative to the torrent's root.
+func (s *FileStorage) symlink(path string, target []string) error {
+	targetPath, err := s.target.symlinkTargetPath(s.dir, target)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(filepath.Dir(path), targetPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(rel, path)
+}
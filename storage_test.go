@@ -0,0 +1,148 @@
+package bytedribble
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newAttrTorrent returns a torrent of files, each filled with the first character of its name.
+func newAttrTorrent(t *testing.T, files []map[string]any) Metainfo {
+	t.Helper()
+	var data []byte
+	for _, f := range files {
+		length := f["length"].(int)
+		if f["attr"] == "p" {
+			data = append(data, make([]byte, length)...)
+		} else {
+			path := f["path"].([]string)
+			data = append(data, bytes.Repeat([]byte(path[len(path)-1][:1]), length)...)
+		}
+	}
+	var pieces []byte
+	for off := 0; off < len(data); off += 8 {
+		end := off + 8
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha1.Sum(data[off:end])
+		pieces = append(pieces, h[:]...)
+	}
+	raw, err := bencoding.Marshal(map[string]any{
+		"announce": "http://tracker",
+		"info": map[string]any{
+			"name":         "dir",
+			"piece length": 8,
+			"pieces":       pieces,
+			"files":        files,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func attrTestFiles() []map[string]any {
+	hash := sha1.Sum([]byte("aaaaa"))
+	return []map[string]any{
+		{"length": 5, "path": []string{"a"}, "attr": "x", "sha1": hash[:]},
+		{"length": 3, "path": []string{".pad", "3"}, "attr": "p"},
+		{"length": 4, "path": []string{"sub", "b"}},
+		{"length": 0, "path": []string{"sub", "link"}, "attr": "l", "symlink path": []string{"a"}},
+		{"length": 0, "path": []string{"empty"}},
+		{"length": 2, "path": []string{".hidden"}, "attr": "h"},
+	}
+}
+
+func TestParseMetainfo_FileAttributes(t *testing.T) {
+	meta := newAttrTorrent(t, attrTestFiles())
+	if !assert.Len(t, meta.Files, 6) {
+		return
+	}
+	assert.True(t, meta.Files[0].IsExecutable())
+	assert.Len(t, meta.Files[0].SHA1, sha1.Size)
+	assert.True(t, meta.Files[1].IsPadding())
+	assert.False(t, meta.Files[2].IsPadding())
+	assert.True(t, meta.Files[3].IsSymlink())
+	assert.Equal(t, []string{"a"}, meta.Files[3].SymlinkPath)
+	assert.True(t, meta.Files[5].IsHidden())
+
+	for name, f := range map[string]map[string]any{
+		"escaping symlink": {"length": 0, "path": []string{"link"}, "attr": "l", "symlink path": []string{"..", "etc"}},
+		"missing symlink":  {"length": 0, "path": []string{"link"}, "attr": "l"},
+		"short sha1":       {"length": 1, "path": []string{"a"}, "sha1": []byte("abc")},
+	} {
+		raw, _ := bencoding.Marshal(map[string]any{
+			"announce": "http://tracker",
+			"info": map[string]any{
+				"name": "dir", "piece length": 8, "pieces": make([]byte, sha1.Size), "files": []any{f},
+			},
+		})
+		_, err := ParseMetainfo(bytes.NewReader(raw))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	meta := newAttrTorrent(t, attrTestFiles())
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir, meta)
+	if !assert.NoError(t, err) {
+		return
+	}
+	data := []byte("aaaaa\x00\x00\x00bbbb..")
+	for i := 0; i < meta.NumPieces(); i++ {
+		p := meta.newPiece(i)
+		fillPiece(p, data[int64(i)*meta.PieceSizeBytes:])
+		if !assert.True(t, p.Valid()) {
+			return
+		}
+		assert.NoError(t, storage.WritePiece(p))
+	}
+	assert.NoError(t, storage.Finish())
+
+	root := filepath.Join(dir, "dir")
+	read := func(path ...string) string {
+		b, err := os.ReadFile(filepath.Join(append([]string{root}, path...)...))
+		assert.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "aaaaa", read("a"))
+	assert.Equal(t, "bbbb", read("sub", "b"))
+	assert.Equal(t, "", read("empty"))
+	assert.Equal(t, "..", read(".hidden"))
+	assert.NoDirExists(t, filepath.Join(root, ".pad"))
+
+	info, err := os.Stat(filepath.Join(root, "a"))
+	if assert.NoError(t, err) {
+		assert.NotZero(t, info.Mode()&0o100, "a should be executable")
+	}
+	info, err = os.Stat(filepath.Join(root, "sub", "b"))
+	if assert.NoError(t, err) {
+		assert.Zero(t, info.Mode()&0o100, "b should not be executable")
+	}
+	target, err := os.Readlink(filepath.Join(root, "sub", "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "a"), target)
+	assert.Equal(t, "aaaaa", read("sub", "link"))
+
+	// web seeds don't host padding files
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(srv.Close)
+	seedURL, _ := url.Parse(srv.URL)
+	got, err := downloadAll(t, NewWebSeed(srv.Client(), seedURL, meta), meta)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
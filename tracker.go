@@ -1,56 +1,102 @@
 package bytedribble
 
 import (
-	"bufio"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"math/rand"
 	"net"
-	"net/http"
 	"net/url"
-	"strconv"
 	"sync"
 	"time"
 )
 
 type TorrentMetrics interface {
-	Uploaded() int
-	Downloaded() int
-	Left() int
+	Uploaded() int64
+	Downloaded() int64
+	Left() int64
 }
 
 type FakeMetrics struct {
-	TotalSize int
+	TotalSize int64
 }
 
-func (m FakeMetrics) Uploaded() int {
+func (m FakeMetrics) Uploaded() int64 {
 	return 0
 }
-func (m FakeMetrics) Downloaded() int {
+func (m FakeMetrics) Downloaded() int64 {
 	return 0
 }
-func (m FakeMetrics) Left() int {
+func (m FakeMetrics) Left() int64 {
 	return m.TotalSize
 }
 
 type TrackerClient struct {
-	client   *http.Client
+	registry *TrackerRegistry
 	target   Metainfo
+	infoHash []byte // identifies the swarm to announce to
 	selfInfo PeerInfo
 	metrics  TorrentMetrics
 
-	mu        sync.Mutex
-	peerCache []PeerInfo
+	mu         sync.Mutex
+	peerCache  []PeerInfo
+	tiers      [][]*TrackerStatus
+	announcers map[string]Announcer // by URL
 }
 
-func NewTrackerClient(client *http.Client, target Metainfo, self PeerInfo, metrics TorrentMetrics) *TrackerClient {
-	return &TrackerClient{
-		client:   client,
-		target:   target,
-		selfInfo: self,
-		metrics:  metrics,
+// TrackerStatus reports the outcome of the most recent announce to one of a torrent's trackers.
+type TrackerStatus struct {
+	URL          *url.URL
+	Tier         int
+	LastAnnounce time.Time     // zero if never contacted
+	LastError    error         // nil if the last announce succeeded
+	Interval     time.Duration // requested by the tracker in its last successful response
+	NumPeers     int           // peers returned in the last successful response
+}
+
+// NewTrackerClient returns a client for the trackers of target. Trackers are tried tier by tier, as described by BEP-12,
+// with the order of trackers within each tier shuffled. Each tracker is reached through the Announcer that registry
+// creates for its URL, or DefaultTrackerRegistry if registry is nil.
+//
+// See: https://www.bittorrent.org/beps/bep_0012.html
+func NewTrackerClient(registry *TrackerRegistry, target Metainfo, self PeerInfo, metrics TorrentMetrics) *TrackerClient {
+	if registry == nil {
+		registry = DefaultTrackerRegistry
+	}
+	c := &TrackerClient{
+		registry:   registry,
+		target:     target,
+		infoHash:   target.InfoHash(),
+		selfInfo:   self,
+		metrics:    metrics,
+		announcers: make(map[string]Announcer),
+	}
+	for i, urls := range target.AnnounceTiers() {
+		tier := make([]*TrackerStatus, len(urls))
+		for j, u := range urls {
+			tier[j] = &TrackerStatus{URL: u, Tier: i}
+		}
+		rand.Shuffle(len(tier), func(a, b int) {
+			tier[a], tier[b] = tier[b], tier[a]
+		})
+		c.tiers = append(c.tiers, tier)
+	}
+	return c
+}
+
+// TrackerStatus returns the status of each of the torrent's trackers, in the order they will next be tried.
+func (c *TrackerClient) TrackerStatus() []TrackerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var statuses []TrackerStatus
+	for _, tier := range c.tiers {
+		for _, tracker := range tier {
+			statuses = append(statuses, *tracker)
+		}
 	}
+	return statuses
 }
 
 func (c *TrackerClient) Run(ctx context.Context) error {
@@ -70,7 +116,8 @@ func (c *TrackerClient) Run(ctx context.Context) error {
 				return err
 			}
 			if newInterval != interval {
-				syncTrackerTicker.Reset(newInterval)
+				interval = newInterval
+				syncTrackerTicker.Reset(interval)
 			}
 		}
 	}
@@ -85,95 +132,235 @@ const (
 	Empty     Event = ""
 )
 
-// syncTracker syncs with the torrent's tracker. Uploads metrics and current progress and receives a peer list.
+// AnnounceRequest describes the local peer and its progress to a tracker.
+type AnnounceRequest struct {
+	InfoHash   []byte
+	PeerID     PeerID
+	IP         net.IP // optional, trackers otherwise use the address the announce came from
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	NumWant    int // -1 for the tracker's default
+}
+
+// AnnounceResponse is a tracker's response to an announce.
+type AnnounceResponse struct {
+	Interval    time.Duration // to wait before announcing again
+	MinInterval time.Duration // not to announce again before, zero if unspecified
+	Peers       []PeerInfo
+	Seeders     int
+	Leechers    int
+}
+
+// ScrapeResult holds a tracker's statistics for one torrent.
+type ScrapeResult struct {
+	Complete   int    `bencode:"complete"`       // peers with the entire torrent
+	Downloaded int    `bencode:"downloaded"`     // times the torrent has been downloaded
+	Incomplete int    `bencode:"incomplete"`     // peers still downloading
+	Name       string `bencode:"name,omitempty"` // the torrent's name, if the tracker reports it
+}
+
+// Scraper is implemented by Announcers that can also report a tracker's statistics for torrents without announcing.
+type Scraper interface {
+	Scrape(ctx context.Context, infoHashes ...[]byte) ([]ScrapeResult, error)
+}
+
+// ErrScrapeUnsupported is returned when a tracker does not support scraping.
+var ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+
+// syncTracker syncs with the torrent's trackers. Uploads metrics and current progress and receives a peer list.
+//
+// Trackers are tried in order until one responds. A tracker that responds is moved to the front of its tier, so it is
+// tried first next time.
 //
 // See: https://www.bittorrent.org/beps/bep_0003.html#trackers
-// TODO implement UDP tracker support https://www.bittorrent.org/beps/bep_0015.html
 func (c *TrackerClient) syncTracker(ctx context.Context, event Event) (time.Duration, error) {
-	req, err := c.createTrackerRequest(ctx, event)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create tracker request: %w", err)
+	c.mu.Lock()
+	var order []*TrackerStatus
+	for _, tier := range c.tiers {
+		order = append(order, tier...)
 	}
-	interval, peers, err := c.sendTrackerRequest(req)
-	if err != nil {
-		return 0, err
+	c.mu.Unlock()
+	if len(order) == 0 {
+		return 0, errors.New("no trackers")
 	}
 
+	var lastErr error
+	for _, tracker := range order {
+		interval, peers, err := c.announce(ctx, tracker.URL, event)
+
+		c.mu.Lock()
+		tracker.LastAnnounce = time.Now()
+		tracker.LastError = err
+		if err == nil {
+			tracker.Interval = interval
+			tracker.NumPeers = len(peers)
+			c.peerCache = peers
+			c.promote(tracker)
+		}
+		c.mu.Unlock()
+
+		if err == nil {
+			return interval, nil
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		lastErr = fmt.Errorf("tracker %s: %w", tracker.URL, err)
+	}
+	if len(order) == 1 {
+		return 0, lastErr
+	}
+	return 0, fmt.Errorf("all %d trackers failed, last error: %w", len(order), lastErr)
+}
+
+// Scrape returns the statistics of the torrents with the given infohashes, in order, or of the client's own torrent if
+// none are given. Trackers are tried in the same order as announces until one responds.
+//
+// See: https://www.bittorrent.org/beps/bep_0048.html
+func (c *TrackerClient) Scrape(ctx context.Context, infoHashes ...[]byte) ([]ScrapeResult, error) {
+	if len(infoHashes) == 0 {
+		infoHashes = [][]byte{c.infoHash}
+	}
 	c.mu.Lock()
-	c.peerCache = peers
+	var order []*url.URL
+	for _, tier := range c.tiers {
+		for _, tracker := range tier {
+			order = append(order, tracker.URL)
+		}
+	}
 	c.mu.Unlock()
+	if len(order) == 0 {
+		return nil, errors.New("no trackers")
+	}
 
-	return interval, nil
+	var lastErr error
+	for _, u := range order {
+		announcer, err := c.announcer(u)
+		if err != nil {
+			lastErr = fmt.Errorf("tracker %s: %w", u, err)
+			continue
+		}
+		scraper, ok := announcer.(Scraper)
+		if !ok {
+			lastErr = fmt.Errorf("tracker %s: %w", u, ErrScrapeUnsupported)
+			continue
+		}
+		results, err := scraper.Scrape(ctx, infoHashes...)
+		if err == nil {
+			return results, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = fmt.Errorf("tracker %s: %w", u, err)
+	}
+	if len(order) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("all %d trackers failed, last error: %w", len(order), lastErr)
 }
 
-func (c *TrackerClient) createTrackerRequest(ctx context.Context, event Event) (*http.Request, error) {
-	query := url.Values{}
-	query.Set("info_hash", string(c.target.InfoHash()))
-	query.Set("peer_id", string(c.selfInfo.PeerID[:]))
-	// TODO ip? (optional)
-	query.Set("port", strconv.Itoa(c.selfInfo.Port))
-	query.Set("uploaded", strconv.Itoa(c.metrics.Uploaded()))
-	query.Set("downloaded", strconv.Itoa(c.metrics.Downloaded()))
-	query.Set("left", strconv.Itoa(c.metrics.Left()))
-	// TODO implement support for parsing compact peer list https://www.bittorrent.org/beps/bep_0023.html
-	query.Set("compact", "0") // Disable compact peer list
-	if event != Empty {
-		query.Set("event", string(event))
+// promote moves tracker to the front of its tier. c.mu must be held.
+func (c *TrackerClient) promote(tracker *TrackerStatus) {
+	tier := c.tiers[tracker.Tier]
+	for i, t := range tier {
+		if t == tracker {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = tracker
+			return
+		}
 	}
-	return http.NewRequestWithContext(ctx, http.MethodGet, c.target.TrackerURL.String()+"?"+query.Encode(), nil)
 }
 
-func (c *TrackerClient) sendTrackerRequest(req *http.Request) (time.Duration, []PeerInfo, error) {
-	rawResp, err := c.client.Do(req)
+// minAnnounceInterval is the shortest interval between announces. Announcers are pluggable, and an interval of 0 or
+// less would otherwise have the client announce in a tight loop, or panic creating its ticker.
+const minAnnounceInterval = time.Second
+
+// announce sends a single announce request to the tracker at trackerURL, and returns the interval until the next one,
+// which is at least minAnnounceInterval.
+func (c *TrackerClient) announce(ctx context.Context, trackerURL *url.URL, event Event) (time.Duration, []PeerInfo, error) {
+	announcer, err := c.announcer(trackerURL)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := announcer.Announce(ctx, AnnounceRequest{
+		InfoHash:   c.infoHash,
+		PeerID:     c.selfInfo.PeerID,
+		IP:         c.selfInfo.IP,
+		Port:       c.selfInfo.Port,
+		Uploaded:   c.metrics.Uploaded(),
+		Downloaded: c.metrics.Downloaded(),
+		Left:       c.metrics.Left(),
+		Event:      event,
+		NumWant:    -1,
+	})
 	if err != nil {
 		return 0, nil, err
 	}
-	if rawResp.StatusCode != http.StatusOK {
-		return 0, nil, fmt.Errorf("tracker responded with unexpected HTTP error code: %d", rawResp.StatusCode)
+	if resp.Interval < minAnnounceInterval {
+		resp.Interval = minAnnounceInterval
 	}
+	return resp.Interval, resp.Peers, nil
+}
 
-	defer rawResp.Body.Close()
-	resp, err := bencoding.UnmarshalDict(bufio.NewReader(rawResp.Body))
+// announcer returns the Announcer for the tracker at trackerURL, creating it on first use so that any state it keeps
+// between announces, such as a UDP connection ID, is reused.
+func (c *TrackerClient) announcer(trackerURL *url.URL) (Announcer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if a, ok := c.announcers[trackerURL.String()]; ok {
+		return a, nil
+	}
+	a, err := c.registry.Announcer(trackerURL)
 	if err != nil {
-		return 0, nil, fmt.Errorf("failed to parse tracker response: %w", err)
+		return nil, err
 	}
+	c.announcers[trackerURL.String()] = a
+	return a, nil
+}
 
-	if failure, ok := resp["failure"]; ok {
-		return 0, nil, fmt.Errorf("tracker returned error: %s", failure.(string))
-	}
+// networkDecodeOptions bounds the resources spent decoding bencoded data received from trackers and peers, which
+// must be treated as hostile.
+var networkDecodeOptions = bencoding.DecodeOptions{
+	MaxDepth:        16,
+	MaxStringLength: 1 << 20,
+	MaxTotalBytes:   4 << 20,
+	MaxDictEntries:  1 << 10,
+}
 
-	intervalSeconds, ok := resp["interval"].(int)
-	if !ok {
-		return 0, nil, errors.New("missing interval")
-	}
+// A TrackerFailureError is returned when a tracker responds with a failure reason.
+type TrackerFailureError struct {
+	Reason string
+}
 
+func (e *TrackerFailureError) Error() string {
+	return "tracker returned error: " + e.Reason
+}
+
+// parseCompactPeers parses a compact peer list, in which each peer is an IP address of ipLen bytes followed by a
+// 2 byte port, both in network byte order. Compact peers never include peer ids.
+//
+// See: https://www.bittorrent.org/beps/bep_0023.html
+// See: https://www.bittorrent.org/beps/bep_0007.html
+func parseCompactPeers(data []byte, ipLen int) ([]PeerInfo, error) {
+	entryLen := ipLen + 2
+	if len(data)%entryLen != 0 {
+		return nil, fmt.Errorf("compact peer list length %d is not a multiple of %d", len(data), entryLen)
+	}
 	var peers []PeerInfo
-	peerDicts, ok := resp["peers"].([]any)
-	if !ok {
-		return 0, nil, errors.New("missing peer list")
-	}
-	for _, pd := range peerDicts {
-		p := pd.(map[string]any)
-		pi := PeerInfo{}
-		if id, ok := p["peer id"].(string); !ok || len([]byte(id)) != peerIDLen {
-			return 0, nil, errors.New("missing valid peer id")
-		} else {
-			pi.PeerID = PeerIDFromString(id)
-		}
-		if ipString, ok := p["ip"].(string); !ok {
-			return 0, nil, errors.New("missing peer ip address")
-		} else {
-			pi.IP = net.ParseIP(ipString)
-		}
-		if port, ok := p["port"].(int); !ok {
-			return 0, nil, errors.New("missing peer port number")
-		} else {
-			pi.Port = port
+	for off := 0; off < len(data); off += entryLen {
+		ip := make(net.IP, ipLen)
+		copy(ip, data[off:off+ipLen])
+		port := int(binary.BigEndian.Uint16(data[off+ipLen:]))
+		if port == 0 {
+			return nil, errors.New("missing peer port number")
 		}
-		peers = append(peers, pi)
+		peers = append(peers, PeerInfo{IP: ip, Port: port})
 	}
-
-	return time.Duration(intervalSeconds) * time.Second, peers, nil
+	return peers, nil
 }
 
 func (c *TrackerClient) RequestNewPeers(ctx context.Context) ([]PeerInfo, error) {
@@ -184,6 +371,29 @@ func (c *TrackerClient) RequestNewPeers(ctx context.Context) ([]PeerInfo, error)
 	return c.Peers(), nil
 }
 
+// Kind identifies trackers as a PeerSource.
+func (c *TrackerClient) Kind() PeerSourceKind {
+	return TrackerSource
+}
+
+// TrackerURLs returns the URLs of the trackers the client announces to.
+func (c *TrackerClient) TrackerURLs() []*url.URL {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var urls []*url.URL
+	for _, tier := range c.tiers {
+		for _, status := range tier {
+			urls = append(urls, status.URL)
+		}
+	}
+	return urls
+}
+
+// FindPeers announces to the trackers and returns the peers they respond with.
+func (c *TrackerClient) FindPeers(ctx context.Context) ([]PeerInfo, error) {
+	return c.RequestNewPeers(ctx)
+}
+
 func (c *TrackerClient) Peers() []PeerInfo {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -0,0 +1,234 @@
+package tracker
+
+import (
+	"errors"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Handler serves the HTTP tracker protocol from a set of swarms. Announces are served at any path whose last element
+// is "announce", and scrapes at any path whose last element is "scrape".
+//
+// Peers are recorded at the address their request came from; the optional ip parameter is ignored, since it would
+// let anyone add other hosts to a swarm.
+//
+// See: https://www.bittorrent.org/beps/bep_0003.html#trackers
+// See: https://www.bittorrent.org/beps/bep_0023.html
+// See: https://www.bittorrent.org/beps/bep_0048.html
+type Handler struct {
+	backend backend
+}
+
+func NewHandler(swarms *Swarms) *Handler {
+	return &Handler{backend: swarms}
+}
+
+// NewPrivateHandler returns a handler for a private tracker, which expects announce URLs of the form
+// http://host/<passkey>/announce.
+func NewPrivateHandler(private *Private) *Handler {
+	return &Handler{backend: private}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "announce":
+		h.announce(w, r)
+	case "scrape":
+		h.scrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// announceResponse is the bencoded body of an announce response.
+type announceResponse struct {
+	Interval    int    `bencode:"interval"`
+	MinInterval int    `bencode:"min interval,omitempty"`
+	Complete    int    `bencode:"complete"`
+	Incomplete  int    `bencode:"incomplete"`
+	Peers       any    `bencode:"peers"`            // compact IPv4 peers, or a list of dictPeer
+	Peers6      []byte `bencode:"peers6,omitempty"` // compact IPv6 peers (BEP-7)
+}
+
+// dictPeer is a peer in the dictionary model of the peer list.
+type dictPeer struct {
+	PeerID []byte `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+}
+
+func (h *Handler) announce(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAnnounce(r)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+	resp, err := h.backend.announce(r.Context(), passkeyFromPath(r.URL.Path), req)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	body := announceResponse{
+		Interval:    intervalSeconds(resp.Interval),
+		MinInterval: int(resp.MinInterval.Seconds()),
+		Complete:    resp.Seeders,
+		Incomplete:  resp.Leechers,
+	}
+	// compact peer lists are the default, as clients that do not ask for them generally accept them anyway
+	if query.Get("compact") != "0" {
+		peers := []byte{}
+		for _, p := range resp.Peers {
+			if ip4 := p.IP.To4(); ip4 != nil {
+				peers = appendCompactPeer(peers, ip4, p.Port)
+			} else {
+				body.Peers6 = appendCompactPeer(body.Peers6, p.IP.To16(), p.Port)
+			}
+		}
+		body.Peers = peers
+	} else {
+		noPeerID := query.Get("no_peer_id") == "1"
+		peers := []dictPeer{}
+		for _, p := range resp.Peers {
+			dp := dictPeer{IP: p.IP.String(), Port: p.Port}
+			if !noPeerID {
+				dp.PeerID = p.PeerID.Bytes()
+			}
+			peers = append(peers, dp)
+		}
+		body.Peers = peers
+	}
+	writeBencoded(w, body)
+}
+
+// parseAnnounce parses the query of an announce request. uploaded and downloaded default to 0 if omitted.
+func parseAnnounce(r *http.Request) (bytedribble.AnnounceRequest, error) {
+	query := r.URL.Query()
+	req := bytedribble.AnnounceRequest{
+		InfoHash: []byte(query.Get("info_hash")),
+		NumWant:  -1,
+	}
+	if len(req.InfoHash) != 20 {
+		return req, errors.New("invalid info_hash")
+	}
+	peerID := query.Get("peer_id")
+	if len(peerID) != 20 {
+		return req, errors.New("invalid peer_id")
+	}
+	req.PeerID = bytedribble.PeerIDFromString(peerID)
+
+	port, err := strconv.Atoi(query.Get("port"))
+	if err != nil || port <= 0 || port > 0xffff {
+		return req, errors.New("invalid port")
+	}
+	req.Port = port
+	if req.Left, err = strconv.ParseInt(query.Get("left"), 10, 64); err != nil || req.Left < 0 {
+		return req, errors.New("invalid left")
+	}
+	for _, field := range []struct {
+		name string
+		v    *int64
+	}{
+		{"uploaded", &req.Uploaded},
+		{"downloaded", &req.Downloaded},
+	} {
+		if s := query.Get(field.name); s != "" {
+			if *field.v, err = strconv.ParseInt(s, 10, 64); err != nil || *field.v < 0 {
+				return req, errors.New("invalid " + field.name)
+			}
+		}
+	}
+	if s := query.Get("numwant"); s != "" {
+		if req.NumWant, err = strconv.Atoi(s); err != nil || req.NumWant < 0 {
+			return req, errors.New("invalid numwant")
+		}
+	}
+
+	switch event := bytedribble.Event(query.Get("event")); event {
+	case bytedribble.Started, bytedribble.Stopped, bytedribble.Completed:
+		req.Event = event
+	default:
+		// includes events from extensions, like BEP-21's "paused", which are treated as regular announces
+		req.Event = bytedribble.Empty
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return req, errors.New("unknown peer address")
+	}
+	if req.IP = net.ParseIP(host); req.IP == nil {
+		return req, errors.New("unknown peer address")
+	}
+	if ip4 := req.IP.To4(); ip4 != nil {
+		req.IP = ip4
+	}
+	return req, nil
+}
+
+// intervalSeconds returns an announce interval in whole seconds, which is at least 1 since clients reject (or spin
+// on) an interval of 0.
+func intervalSeconds(d time.Duration) int {
+	if d < time.Second {
+		return 1
+	}
+	return int(d.Seconds())
+}
+
+// appendCompactPeer appends a peer in the compact format: its IP address followed by its port, in network byte order.
+func appendCompactPeer(buf []byte, ip net.IP, port int) []byte {
+	buf = append(buf, ip...)
+	return append(buf, byte(port>>8), byte(port))
+}
+
+// scrapeResponse is the bencoded body of a scrape response.
+type scrapeResponse struct {
+	Files map[string]bytedribble.ScrapeResult `bencode:"files"`
+}
+
+func (h *Handler) scrape(w http.ResponseWriter, r *http.Request) {
+	var infoHashes [][]byte
+	for _, s := range r.URL.Query()["info_hash"] {
+		if len(s) != 20 {
+			writeFailure(w, "invalid info_hash")
+			return
+		}
+		infoHashes = append(infoHashes, []byte(s))
+	}
+	// a scrape without infohashes is for every torrent
+	infoHashes, results, err := h.backend.scrape(r.Context(), passkeyFromPath(r.URL.Path), infoHashes)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+	body := scrapeResponse{Files: make(map[string]bytedribble.ScrapeResult)}
+	for i, h := range infoHashes {
+		body.Files[string(h)] = results[i]
+	}
+	writeBencoded(w, body)
+}
+
+// failureResponse is the bencoded body of a failed request. Failures are still sent with status 200, so that clients
+// read the reason.
+type failureResponse struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+func writeFailure(w http.ResponseWriter, reason string) {
+	writeBencoded(w, failureResponse{FailureReason: reason})
+}
+
+func writeBencoded(w http.ResponseWriter, v any) {
+	body, err := bencoding.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write(body)
+}
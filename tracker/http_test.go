@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"context"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHandler_Announce(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(NewSwarms(time.Minute)))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := bytedribble.NewHTTPTracker(srv.Client(), u)
+	ctx := context.Background()
+
+	req := testAnnounce("-DR0001-000000000001", 0, bytedribble.Started)
+	_, err = client.Announce(ctx, req)
+	assert.NoError(t, err)
+
+	req = testAnnounce("-DR0001-000000000002", 10, bytedribble.Started)
+	req.Port = 6882
+	resp, err := client.Announce(ctx, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, time.Minute, resp.Interval)
+	assert.Equal(t, 30*time.Second, resp.MinInterval)
+	assert.Equal(t, 1, resp.Seeders)
+	assert.Equal(t, 1, resp.Leechers)
+	if assert.Len(t, resp.Peers, 1) {
+		// recorded at the address the request came from
+		assert.Equal(t, "127.0.0.1:6881", resp.Peers[0].Addr())
+	}
+
+	results, err := client.Scrape(ctx, req.InfoHash, make([]byte, 20))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []bytedribble.ScrapeResult{{Complete: 1, Incomplete: 1}, {}}, results)
+	}
+
+	req.InfoHash = []byte("short")
+	_, err = client.Announce(ctx, req)
+	var failure *bytedribble.TrackerFailureError
+	if assert.ErrorAs(t, err, &failure) {
+		assert.Equal(t, "invalid info_hash", failure.Reason)
+	}
+}
+
+func announceQuery(peerID, port string, extra url.Values) string {
+	query := url.Values{
+		"info_hash": {"aaaaaaaaaaaaaaaaaaaa"},
+		"peer_id":   {peerID},
+		"port":      {port},
+		"left":      {"10"},
+	}
+	for k, v := range extra {
+		query[k] = v
+	}
+	return "/announce?" + query.Encode()
+}
+
+func serveAnnounce(t *testing.T, h http.Handler, remoteAddr, target string) map[string]any {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var resp map[string]any
+	if err := bencoding.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandler_PeerModels(t *testing.T) {
+	h := NewHandler(NewSwarms(time.Minute))
+	serveAnnounce(t, h, "10.0.0.1:1234", announceQuery("-DR0001-000000000001", "6881", nil))
+	serveAnnounce(t, h, "[2001:db8::1]:1234", announceQuery("-DR0001-000000000002", "6882", nil))
+
+	resp := serveAnnounce(t, h, "10.0.0.3:1234", announceQuery("-DR0001-000000000003", "6883", url.Values{"compact": {"1"}}))
+	assert.Equal(t, "\x0a\x00\x00\x01\x1a\xe1", resp["peers"])
+	assert.Equal(t, "\x20\x01\x0d\xb8"+string(make([]byte, 11))+"\x01\x1a\xe2", resp["peers6"])
+	assert.EqualValues(t, 3, resp["incomplete"])
+
+	resp = serveAnnounce(t, h, "10.0.0.3:1234", announceQuery("-DR0001-000000000003", "6883", url.Values{"compact": {"0"}, "numwant": {"1"}}))
+	if peers, ok := resp["peers"].([]any); assert.True(t, ok) && assert.Len(t, peers, 1) {
+		assert.Contains(t, peers[0], "peer id")
+	}
+
+	resp = serveAnnounce(t, h, "10.0.0.3:1234", announceQuery("-DR0001-000000000003", "6883", url.Values{"compact": {"0"}, "no_peer_id": {"1"}}))
+	if peers, ok := resp["peers"].([]any); assert.True(t, ok) && assert.Len(t, peers, 2) {
+		assert.NotContains(t, peers[0], "peer id")
+		assert.NotContains(t, peers[1], "peer id")
+	}
+
+	resp = serveAnnounce(t, h, "10.0.0.3:1234", announceQuery("-DR0001-000000000003", "0", nil))
+	assert.Equal(t, "invalid port", resp["failure reason"])
+}
+
+func TestHandler_Scrape(t *testing.T) {
+	h := NewHandler(NewSwarms(time.Minute))
+	serveAnnounce(t, h, "10.0.0.1:1234", announceQuery("-DR0001-000000000001", "6881", url.Values{"left": {"0"}}))
+
+	// a scrape without infohashes covers every torrent
+	resp := serveAnnounce(t, h, "10.0.0.1:1234", "/scrape")
+	assert.Equal(t, map[string]any{
+		"files": map[string]any{
+			"aaaaaaaaaaaaaaaaaaaa": map[string]any{"complete": int64(1), "downloaded": int64(0), "incomplete": int64(0)},
+		},
+	}, resp)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/elsewhere", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandler_ShortInterval(t *testing.T) {
+	swarms := NewSwarms(time.Minute)
+	swarms.Interval = 10 * time.Millisecond
+	resp := serveAnnounce(t, NewHandler(swarms), "10.0.0.1:1234", announceQuery("-DR0001-000000000001", "6881", nil))
+	assert.EqualValues(t, 1, resp["interval"])
+}
@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"path"
+	"strings"
+)
+
+var (
+	errUnknownPasskey = errors.New("unknown passkey")
+	errUnregistered   = errors.New("unregistered torrent")
+)
+
+// Private is a private tracker: only users of its store may announce, and only torrents the store allows are tracked.
+// The traffic each user reports is added to their totals in the store.
+type Private struct {
+	swarms *Swarms
+	store  Store
+}
+
+func NewPrivate(swarms *Swarms, store Store) *Private {
+	return &Private{swarms: swarms, store: store}
+}
+
+// Announce announces a peer of the user with the given passkey, as Swarms.Announce does.
+func (p *Private) Announce(ctx context.Context, passkey string, req bytedribble.AnnounceRequest) (bytedribble.AnnounceResponse, error) {
+	if err := p.check(passkey); err != nil {
+		return bytedribble.AnnounceResponse{}, err
+	}
+	allowed, err := p.store.Allowed(req.InfoHash)
+	if err != nil {
+		return bytedribble.AnnounceResponse{}, fmt.Errorf("failed to look up torrent: %w", err)
+	}
+	if !allowed {
+		return bytedribble.AnnounceResponse{}, errUnregistered
+	}
+	resp, t, err := p.swarms.record(ctx, req)
+	if err != nil {
+		return bytedribble.AnnounceResponse{}, err
+	}
+	if t != (traffic{}) {
+		if err := p.store.AddTraffic(passkey, t.uploaded, t.downloaded); err != nil {
+			return bytedribble.AnnounceResponse{}, fmt.Errorf("failed to record traffic: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// Scrape returns the statistics of each of the torrents, in order, to the user with the given passkey. Torrents that
+// are not allowed have zero statistics.
+func (p *Private) Scrape(ctx context.Context, passkey string, infoHashes ...[]byte) ([]bytedribble.ScrapeResult, error) {
+	_, results, err := p.scrape(ctx, passkey, infoHashes)
+	return results, err
+}
+
+// check returns an error unless passkey belongs to a user.
+func (p *Private) check(passkey string) error {
+	if passkey == "" {
+		return errUnknownPasskey
+	}
+	_, ok, err := p.store.User(passkey)
+	if err != nil {
+		return fmt.Errorf("failed to look up passkey: %w", err)
+	}
+	if !ok {
+		return errUnknownPasskey
+	}
+	return nil
+}
+
+func (p *Private) announce(ctx context.Context, passkey string, req bytedribble.AnnounceRequest) (bytedribble.AnnounceResponse, error) {
+	return p.Announce(ctx, passkey, req)
+}
+
+func (p *Private) scrape(ctx context.Context, passkey string, infoHashes [][]byte) ([][]byte, []bytedribble.ScrapeResult, error) {
+	if err := p.check(passkey); err != nil {
+		return nil, nil, err
+	}
+	full := len(infoHashes) == 0
+	if full {
+		infoHashes = p.swarms.InfoHashes()
+	}
+	var hashes [][]byte
+	var results []bytedribble.ScrapeResult
+	for _, h := range infoHashes {
+		allowed, err := p.store.Allowed(h)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to look up torrent: %w", err)
+		}
+		result := []bytedribble.ScrapeResult{{}}
+		if allowed {
+			if result, err = p.swarms.Scrape(ctx, h); err != nil {
+				return nil, nil, err
+			}
+		} else if full {
+			continue
+		}
+		hashes = append(hashes, h)
+		results = append(results, result[0])
+	}
+	return hashes, results, nil
+}
+
+// passkeyFromPath returns the passkey in a path of the form /<passkey>/announce, ignoring any query, or "" if there is
+// none.
+func passkeyFromPath(p string) string {
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p = p[:i]
+	}
+	dir := path.Dir(p)
+	if dir == "/" || dir == "." {
+		return ""
+	}
+	return path.Base(dir)
+}
+
+// NewPasskey returns a new random passkey.
+func NewPasskey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"context"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestPrivate(t *testing.T) (*Private, *MemoryStore) {
+	store := NewMemoryStore()
+	assert.NoError(t, store.AddUser(User{Name: "alice", Passkey: "alicekey"}))
+	assert.NoError(t, store.Allow([]byte("aaaaaaaaaaaaaaaaaaaa")))
+	return NewPrivate(NewSwarms(time.Minute), store), store
+}
+
+func TestPrivate_Announce(t *testing.T) {
+	p, store := newTestPrivate(t)
+	ctx := context.Background()
+
+	req := testAnnounce("-DR0001-000000000001", 10, bytedribble.Started)
+	req.Uploaded, req.Downloaded = 5, 7
+	_, err := p.Announce(ctx, "alicekey", req)
+	assert.NoError(t, err)
+	req.Event = bytedribble.Empty
+	req.Uploaded, req.Downloaded = 15, 17
+	_, err = p.Announce(ctx, "alicekey", req)
+	assert.NoError(t, err)
+	u, ok, err := store.User("alicekey")
+	if assert.NoError(t, err) && assert.True(t, ok) {
+		assert.Equal(t, int64(15), u.Uploaded)
+		assert.Equal(t, int64(17), u.Downloaded)
+	}
+
+	_, err = p.Announce(ctx, "malloryKey", req)
+	assert.ErrorIs(t, err, errUnknownPasskey)
+	_, err = p.Announce(ctx, "", req)
+	assert.ErrorIs(t, err, errUnknownPasskey)
+	req.InfoHash = make([]byte, 20)
+	_, err = p.Announce(ctx, "alicekey", req)
+	assert.ErrorIs(t, err, errUnregistered)
+}
+
+func TestPrivate_Scrape(t *testing.T) {
+	p, _ := newTestPrivate(t)
+	ctx := context.Background()
+	allowed := []byte("aaaaaaaaaaaaaaaaaaaa")
+	_, err := p.Announce(ctx, "alicekey", testAnnounce("-DR0001-000000000001", 0, bytedribble.Started))
+	assert.NoError(t, err)
+	// announced before the tracker became private
+	_, err = p.swarms.Announce(ctx, testAnnounce("-DR0001-000000000001", 0, bytedribble.Started))
+	assert.NoError(t, err)
+	unlisted := testAnnounce("-DR0001-000000000001", 0, bytedribble.Started)
+	unlisted.InfoHash = make([]byte, 20)
+	_, err = p.swarms.Announce(ctx, unlisted)
+	assert.NoError(t, err)
+
+	results, err := p.Scrape(ctx, "alicekey", allowed, unlisted.InfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []bytedribble.ScrapeResult{{Complete: 1}, {}}, results)
+	}
+	hashes, _, err := p.scrape(ctx, "alicekey", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]byte{allowed}, hashes)
+	}
+	_, err = p.Scrape(ctx, "", allowed)
+	assert.ErrorIs(t, err, errUnknownPasskey)
+}
+
+func TestPrivate_Frontends(t *testing.T) {
+	p, _ := newTestPrivate(t)
+	srv := httptest.NewServer(NewPrivateHandler(p))
+	defer srv.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() { _ = NewPrivateUDPServer(p).Serve(conn) }()
+
+	for _, test := range []struct {
+		name string
+		url  string
+	}{
+		{"http", srv.URL},
+		{"udp", "udp://" + conn.LocalAddr().String()},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			registry := bytedribble.NewTrackerRegistry(srv.Client())
+			announcer := func(path string) bytedribble.Announcer {
+				u, err := url.Parse(test.url + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				a, err := registry.Announcer(u)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return a
+			}
+
+			_, err := announcer("/alicekey/announce").Announce(context.Background(), testAnnounce("-DR0001-000000000001", 0, bytedribble.Started))
+			assert.NoError(t, err)
+
+			_, err = announcer("/malloryKey/announce").Announce(context.Background(), testAnnounce("-DR0001-000000000001", 0, bytedribble.Started))
+			var failure *bytedribble.TrackerFailureError
+			if assert.ErrorAs(t, err, &failure) {
+				assert.Equal(t, "unknown passkey", failure.Reason)
+			}
+		})
+	}
+}
+
+func TestPasskeyFromPath(t *testing.T) {
+	assert.Equal(t, "key", passkeyFromPath("/key/announce"))
+	assert.Equal(t, "key", passkeyFromPath("/tracker/key/announce?foo=bar"))
+	assert.Equal(t, "", passkeyFromPath("/announce"))
+	assert.Equal(t, "", passkeyFromPath(""))
+}
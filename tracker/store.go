@@ -0,0 +1,285 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store holds the users and torrents of a private tracker.
+type Store interface {
+	// User returns the user with the given passkey, and false if there is none.
+	User(passkey string) (User, bool, error)
+	// Allowed reports whether the torrent with the given infohash may be tracked.
+	Allowed(infoHash []byte) (bool, error)
+	// AddTraffic adds to the totals uploaded and downloaded by the user with the given passkey.
+	AddTraffic(passkey string, uploaded, downloaded int64) error
+}
+
+// User is a user of a private tracker, along with the totals they have reported uploading and downloading.
+type User struct {
+	Name       string `json:"name"`
+	Passkey    string `json:"passkey"`
+	Uploaded   int64  `json:"uploaded"`
+	Downloaded int64  `json:"downloaded"`
+}
+
+// MemoryStore is a Store that only keeps its users and torrents in memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	users    map[string]*User // by passkey
+	torrents map[string]bool  // allowed, by infohash
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]*User),
+		torrents: make(map[string]bool),
+	}
+}
+
+// AddUser adds a user, or replaces the user with the same passkey.
+func (s *MemoryStore) AddUser(u User) error {
+	if u.Passkey == "" {
+		return errors.New("missing passkey")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Passkey] = &u
+	return nil
+}
+
+// Allow adds the torrent with the given infohash to the allowlist.
+func (s *MemoryStore) Allow(infoHash []byte) error {
+	if len(infoHash) != 20 {
+		return errors.New("invalid infohash")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.torrents[string(infoHash)] = true
+	return nil
+}
+
+func (s *MemoryStore) User(passkey string) (User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[passkey]
+	if !ok {
+		return User{}, false, nil
+	}
+	return *u, true, nil
+}
+
+func (s *MemoryStore) Allowed(infoHash []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.torrents[string(infoHash)], nil
+}
+
+func (s *MemoryStore) AddTraffic(passkey string, uploaded, downloaded int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[passkey]
+	if !ok {
+		return errUnknownPasskey
+	}
+	u.Uploaded += uploaded
+	u.Downloaded += downloaded
+	return nil
+}
+
+// Users returns all users, ordered by name.
+func (s *MemoryStore) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].Passkey < users[j].Passkey
+	})
+	return users
+}
+
+// AllowedInfoHashes returns the infohashes of all allowed torrents, in ascending order.
+func (s *MemoryStore) AllowedInfoHashes() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashes := make([][]byte, 0, len(s.torrents))
+	for h := range s.torrents {
+		hashes = append(hashes, []byte(h))
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return string(hashes[i]) < string(hashes[j])
+	})
+	return hashes
+}
+
+// trafficSaveDelay is how long a FileStore keeps added traffic in memory before saving it, so that the file is not
+// rewritten on every announce.
+const trafficSaveDelay = 10 * time.Second
+
+// FileStore is a Store kept in a JSON file. Users and torrents are saved as soon as they are added, while traffic is
+// saved at most every trafficSaveDelay; call Flush to save it sooner, like before exiting.
+type FileStore struct {
+	path      string
+	saveDelay time.Duration
+
+	mu        sync.Mutex // serializes changes, so that each is saved in order
+	mem       *MemoryStore
+	dirty     bool        // whether there are changes that have not been saved
+	saveTimer *time.Timer // saves the changes once saveDelay has passed, or nil if none is pending
+}
+
+// storeFile is the contents of a FileStore's file.
+type storeFile struct {
+	Users    []User   `json:"users"`
+	Torrents []string `json:"torrents"` // hex infohashes
+}
+
+// NewFileStore returns the store kept at path. If the file does not exist, the store starts empty and the file is
+// created with the first change.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, saveDelay: trafficSaveDelay, mem: NewMemoryStore()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, u := range f.Users {
+		if err := s.mem.AddUser(u); err != nil {
+			return nil, fmt.Errorf("invalid user %q in %s: %w", u.Name, path, err)
+		}
+	}
+	for _, h := range f.Torrents {
+		infoHash, err := hex.DecodeString(h)
+		if err == nil {
+			err = s.mem.Allow(infoHash)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid torrent %q in %s: %w", h, path, err)
+		}
+	}
+	return s, nil
+}
+
+// AddUser adds a user, or replaces the user with the same passkey.
+func (s *FileStore) AddUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.AddUser(u); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Allow adds the torrent with the given infohash to the allowlist.
+func (s *FileStore) Allow(infoHash []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Allow(infoHash); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileStore) User(passkey string) (User, bool, error) {
+	return s.mem.User(passkey)
+}
+
+func (s *FileStore) Allowed(infoHash []byte) (bool, error) {
+	return s.mem.Allowed(infoHash)
+}
+
+func (s *FileStore) AddTraffic(passkey string, uploaded, downloaded int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.AddTraffic(passkey, uploaded, downloaded); err != nil {
+		return err
+	}
+	s.dirty = true
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(s.saveDelay, s.saveLater)
+	}
+	return nil
+}
+
+// Flush saves any traffic that has not been saved yet.
+func (s *FileStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// saveLater saves the changes made since the save timer was started. If saving fails, the changes remain unsaved
+// until the next save, and Flush reports the error.
+func (s *FileStore) saveLater() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveTimer = nil
+	if s.dirty {
+		_ = s.save()
+	}
+}
+
+// Users returns all users, ordered by name.
+func (s *FileStore) Users() []User {
+	return s.mem.Users()
+}
+
+// save writes the store to a temporary file, syncs it and renames it over the store's file, so that the file is never
+// left partly written. s.mu must be held.
+func (s *FileStore) save() error {
+	f := storeFile{Users: s.mem.Users(), Torrents: []string{}}
+	for _, h := range s.mem.AllowedInfoHashes() {
+		f.Torrents = append(f.Torrents, hex.EncodeToString(h))
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.json")
+	s, err := NewFileStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, s.AddUser(User{Name: "alice", Passkey: "alicekey"}))
+	assert.Error(t, s.AddUser(User{Name: "bob"}))
+	assert.NoError(t, s.Allow([]byte("aaaaaaaaaaaaaaaaaaaa")))
+	assert.Error(t, s.Allow([]byte("short")))
+	assert.NoError(t, s.AddTraffic("alicekey", 3, 4))
+	assert.ErrorIs(t, s.AddTraffic("bobkey", 3, 4), errUnknownPasskey)
+	assert.NoError(t, s.Flush())
+
+	// reopened from the file
+	s, err = NewFileStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []User{{Name: "alice", Passkey: "alicekey", Uploaded: 3, Downloaded: 4}}, s.Users())
+	allowed, err := s.Allowed([]byte("aaaaaaaaaaaaaaaaaaaa"))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = s.Allowed(make([]byte, 20))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if assert.NoError(t, err) {
+		assert.Len(t, entries, 1, "temporary files are cleaned up")
+	}
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"torrents": ["zz"]}`), 0o600))
+	_, err = NewFileStore(path)
+	assert.Error(t, err)
+}
+
+func TestFileStore_DelayedTraffic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.json")
+	s, err := NewFileStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	s.saveDelay = 50 * time.Millisecond
+	assert.NoError(t, s.AddUser(User{Name: "alice", Passkey: "alicekey"}))
+
+	saved := func() []User {
+		s, err := NewFileStore(path)
+		if err != nil {
+			return nil
+		}
+		return s.Users()
+	}
+	// traffic is not saved right away, but is after the delay
+	assert.NoError(t, s.AddTraffic("alicekey", 3, 4))
+	assert.NoError(t, s.AddTraffic("alicekey", 3, 4))
+	assert.Equal(t, []User{{Name: "alice", Passkey: "alicekey"}}, saved())
+	want := []User{{Name: "alice", Passkey: "alicekey", Uploaded: 6, Downloaded: 8}}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(want, saved())
+	}, time.Second, 10*time.Millisecond)
+
+	// or when flushed
+	s.saveDelay = time.Hour
+	assert.NoError(t, s.AddTraffic("alicekey", 1, 1))
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, []User{{Name: "alice", Passkey: "alicekey", Uploaded: 7, Downloaded: 9}}, saved())
+	assert.NoError(t, s.Flush())
+}
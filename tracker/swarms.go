@@ -0,0 +1,277 @@
+// Package tracker implements a BitTorrent tracker.
+//
+// Swarms keeps track of the peers of every torrent, and front ends such as Handler and UDPServer serve it to clients
+// over the network. Any number of front ends may share the same Swarms.
+//
+// See: https://www.bittorrent.org/beps/bep_0003.html#trackers
+package tracker
+
+import (
+	"context"
+	"errors"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often peers are asked to announce by default.
+	DefaultInterval = 30 * time.Minute
+
+	defaultNumWant = 50
+	maxNumWant     = 200
+)
+
+// backend answers the requests received by a front end. passkey identifies the user making the request, if the
+// front end found one. A scrape without infohashes is for every torrent the user may see, and returns their
+// infohashes along with their statistics.
+type backend interface {
+	announce(ctx context.Context, passkey string, req bytedribble.AnnounceRequest) (bytedribble.AnnounceResponse, error)
+	scrape(ctx context.Context, passkey string, infoHashes [][]byte) ([][]byte, []bytedribble.ScrapeResult, error)
+}
+
+// Swarms holds the peers announcing each torrent, by infohash, along with statistics about them. It implements
+// bytedribble.Announcer and bytedribble.Scraper, so it can also be announced to in-process.
+//
+// Peers which have not announced within PeerTimeout are forgotten, and so are swarms left without peers. Download
+// counts are kept after their swarm is forgotten.
+type Swarms struct {
+	Interval    time.Duration // how often peers are asked to announce
+	MinInterval time.Duration // how often peers may announce at most
+	PeerTimeout time.Duration // how long after their last announce peers are forgotten
+
+	now func() time.Time
+
+	mu         sync.Mutex
+	torrents   map[string]*swarm // by infohash
+	downloaded map[string]int    // completed events, by infohash
+	swept      time.Time         // when every swarm was last expired
+}
+
+type swarm struct {
+	peers map[peerKey]*swarmPeer
+}
+
+// peerKey identifies a peer in a swarm. The IP address is part of it so that no one can stop or move another host's
+// peer by announcing with its peer id, which is handed out with the dictionary peer model.
+type peerKey struct {
+	id bytedribble.PeerID
+	ip string
+}
+
+type swarmPeer struct {
+	info                 bytedribble.PeerInfo
+	left                 int64
+	uploaded, downloaded int64 // totals from the peer's last announce
+	lastSeen             time.Time
+}
+
+// NewSwarms returns an empty set of swarms asking peers to announce every interval. Peers may announce at most every
+// half interval, and are forgotten if they miss two announces.
+func NewSwarms(interval time.Duration) *Swarms {
+	return &Swarms{
+		Interval:    interval,
+		MinInterval: interval / 2,
+		PeerTimeout: 2 * interval,
+		now:         time.Now,
+		torrents:    make(map[string]*swarm),
+		downloaded:  make(map[string]int),
+	}
+}
+
+// Announce records the peer in the torrent's swarm, or removes it if it stopped, and returns up to req.NumWant of the
+// swarm's other peers, chosen at random. Seeders are only given leechers. req.IP must be set to the address the peer
+// announced from.
+func (s *Swarms) Announce(ctx context.Context, req bytedribble.AnnounceRequest) (bytedribble.AnnounceResponse, error) {
+	resp, _, err := s.record(ctx, req)
+	return resp, err
+}
+
+// traffic is how much a peer reports having transferred since its previous announce.
+type traffic struct {
+	uploaded, downloaded int64
+}
+
+// record implements Announce, also returning the peer's traffic since its previous announce. Peers report their
+// totals since they started, so the first announce of a peer the swarm does not know only counts if it is the
+// started event; otherwise the peer may have been forgotten, and its report only sets a baseline.
+func (s *Swarms) record(ctx context.Context, req bytedribble.AnnounceRequest) (bytedribble.AnnounceResponse, traffic, error) {
+	if err := ctx.Err(); err != nil {
+		return bytedribble.AnnounceResponse{}, traffic{}, err
+	}
+	if len(req.InfoHash) != 20 {
+		return bytedribble.AnnounceResponse{}, traffic{}, errors.New("invalid infohash")
+	}
+	if req.IP == nil {
+		return bytedribble.AnnounceResponse{}, traffic{}, errors.New("missing peer ip address")
+	}
+	if req.Port <= 0 || req.Port > 0xffff {
+		return bytedribble.AnnounceResponse{}, traffic{}, errors.New("invalid port")
+	}
+	if req.Left < 0 || req.Uploaded < 0 || req.Downloaded < 0 {
+		return bytedribble.AnnounceResponse{}, traffic{}, errors.New("invalid transfer statistics")
+	}
+	numWant := req.NumWant
+	if numWant < 0 {
+		numWant = defaultNumWant
+	}
+	if numWant > maxNumWant {
+		numWant = maxNumWant
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	infoHash := string(req.InfoHash)
+	sw := s.lookup(infoHash, now)
+	if sw == nil {
+		if req.Event == bytedribble.Stopped {
+			// nothing to forget
+			return bytedribble.AnnounceResponse{Interval: s.Interval, MinInterval: s.MinInterval}, traffic{}, nil
+		}
+		sw = &swarm{peers: make(map[peerKey]*swarmPeer)}
+		s.torrents[infoHash] = sw
+	}
+	key := peerKey{id: req.PeerID, ip: req.IP.String()}
+	p, known := sw.peers[key]
+	var t traffic
+	switch {
+	case known && req.Uploaded >= p.uploaded && req.Downloaded >= p.downloaded:
+		t = traffic{uploaded: req.Uploaded - p.uploaded, downloaded: req.Downloaded - p.downloaded}
+	case req.Event == bytedribble.Started || known:
+		// a new session, or a client that reset its totals
+		t = traffic{uploaded: req.Uploaded, downloaded: req.Downloaded}
+	}
+	switch req.Event {
+	case bytedribble.Stopped:
+		delete(sw.peers, key)
+		if len(sw.peers) == 0 {
+			delete(s.torrents, infoHash)
+		}
+	default:
+		if req.Event == bytedribble.Completed && (!known || p.left != 0) {
+			// repeated completed events from a peer are only counted once
+			s.downloaded[infoHash]++
+		}
+		sw.peers[key] = &swarmPeer{
+			info:       bytedribble.PeerInfo{PeerID: req.PeerID, IP: req.IP, Port: req.Port},
+			left:       req.Left,
+			uploaded:   req.Uploaded,
+			downloaded: req.Downloaded,
+			lastSeen:   now,
+		}
+	}
+
+	resp := bytedribble.AnnounceResponse{
+		Interval:    s.Interval,
+		MinInterval: s.MinInterval,
+	}
+	for k, p := range sw.peers {
+		if p.left == 0 {
+			resp.Seeders++
+		} else {
+			resp.Leechers++
+		}
+		if k == key || req.Event == bytedribble.Stopped || len(resp.Peers) == numWant {
+			continue
+		}
+		if req.Left == 0 && p.left == 0 {
+			continue
+		}
+		// map iteration order is random, which spreads the load across the swarm
+		resp.Peers = append(resp.Peers, p.info)
+	}
+	return resp, t, nil
+}
+
+// Scrape returns the statistics of each of the torrents, in order. Unknown torrents have zero statistics, apart from
+// how often they were downloaded.
+func (s *Swarms) Scrape(ctx context.Context, infoHashes ...[]byte) ([]bytedribble.ScrapeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	results := make([]bytedribble.ScrapeResult, len(infoHashes))
+	for i, h := range infoHashes {
+		results[i].Downloaded = s.downloaded[string(h)]
+		sw := s.lookup(string(h), now)
+		if sw == nil {
+			continue
+		}
+		for _, p := range sw.peers {
+			if p.left == 0 {
+				results[i].Complete++
+			} else {
+				results[i].Incomplete++
+			}
+		}
+	}
+	return results, nil
+}
+
+func (s *Swarms) announce(ctx context.Context, _ string, req bytedribble.AnnounceRequest) (bytedribble.AnnounceResponse, error) {
+	return s.Announce(ctx, req)
+}
+
+func (s *Swarms) scrape(ctx context.Context, _ string, infoHashes [][]byte) ([][]byte, []bytedribble.ScrapeResult, error) {
+	if len(infoHashes) == 0 {
+		infoHashes = s.InfoHashes()
+	}
+	results, err := s.Scrape(ctx, infoHashes...)
+	return infoHashes, results, err
+}
+
+// InfoHashes returns the infohashes of all torrents with peers, in ascending order.
+func (s *Swarms) InfoHashes() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(s.now())
+	hashes := make([][]byte, 0, len(s.torrents))
+	for h := range s.torrents {
+		hashes = append(hashes, []byte(h))
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return string(hashes[i]) < string(hashes[j])
+	})
+	return hashes
+}
+
+// lookup returns the swarm of a torrent without its expired peers, or nil if it has no peers left. s.mu must be held.
+func (s *Swarms) lookup(infoHash string, now time.Time) *swarm {
+	s.sweep(now)
+	sw, ok := s.torrents[infoHash]
+	if !ok || !s.expire(infoHash, sw, now) {
+		return nil
+	}
+	return sw
+}
+
+// sweep expires the peers of every swarm once per peer timeout, so that swarms which are no longer announced to are
+// eventually forgotten too. s.mu must be held.
+func (s *Swarms) sweep(now time.Time) {
+	if now.Sub(s.swept) < s.PeerTimeout {
+		return
+	}
+	s.swept = now
+	for h, sw := range s.torrents {
+		s.expire(h, sw, now)
+	}
+}
+
+// expire removes the peers of a swarm that have not announced within the peer timeout, and then the swarm itself if it
+// has no peers left. It reports whether the swarm remains. s.mu must be held.
+func (s *Swarms) expire(infoHash string, sw *swarm, now time.Time) bool {
+	for k, p := range sw.peers {
+		if now.Sub(p.lastSeen) > s.PeerTimeout {
+			delete(sw.peers, k)
+		}
+	}
+	if len(sw.peers) == 0 {
+		delete(s.torrents, infoHash)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package tracker
+
+import (
+	"context"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func testAnnounce(id string, left int64, event bytedribble.Event) bytedribble.AnnounceRequest {
+	return bytedribble.AnnounceRequest{
+		InfoHash: []byte("aaaaaaaaaaaaaaaaaaaa"),
+		PeerID:   bytedribble.PeerIDFromString(id),
+		IP:       net.IPv4(10, 0, 0, 1),
+		Port:     6881,
+		Left:     left,
+		Event:    event,
+		NumWant:  -1,
+	}
+}
+
+func TestSwarms_Announce(t *testing.T) {
+	s := NewSwarms(time.Minute)
+	ctx := context.Background()
+
+	resp, err := s.Announce(ctx, testAnnounce("seeder", 0, bytedribble.Started))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, time.Minute, resp.Interval)
+	assert.Equal(t, 30*time.Second, resp.MinInterval)
+	assert.Equal(t, 1, resp.Seeders)
+	assert.Empty(t, resp.Peers)
+
+	resp, err = s.Announce(ctx, testAnnounce("leecher", 10, bytedribble.Started))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Seeders)
+	assert.Equal(t, 1, resp.Leechers)
+	if assert.Len(t, resp.Peers, 1) {
+		assert.Equal(t, bytedribble.PeerIDFromString("seeder"), resp.Peers[0].PeerID)
+	}
+
+	// seeders are not given other seeders
+	_, err = s.Announce(ctx, testAnnounce("seeder2", 0, bytedribble.Started))
+	assert.NoError(t, err)
+	resp, err = s.Announce(ctx, testAnnounce("seeder", 0, bytedribble.Empty))
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Peers, 1) {
+		assert.Equal(t, bytedribble.PeerIDFromString("leecher"), resp.Peers[0].PeerID)
+	}
+
+	req := testAnnounce("leecher", 10, bytedribble.Empty)
+	req.NumWant = 1
+	resp, err = s.Announce(ctx, req)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Peers, 1)
+
+	resp, err = s.Announce(ctx, testAnnounce("seeder2", 0, bytedribble.Stopped))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Seeders)
+	assert.Empty(t, resp.Peers)
+
+	_, err = s.Announce(ctx, bytedribble.AnnounceRequest{InfoHash: make([]byte, 20), Port: 1})
+	assert.Error(t, err)
+}
+
+func TestSwarms_Completed(t *testing.T) {
+	s := NewSwarms(time.Minute)
+	ctx := context.Background()
+	hash := testAnnounce("", 0, bytedribble.Empty).InfoHash
+
+	_, _ = s.Announce(ctx, testAnnounce("leecher", 10, bytedribble.Started))
+	_, _ = s.Announce(ctx, testAnnounce("leecher", 0, bytedribble.Completed))
+	_, _ = s.Announce(ctx, testAnnounce("leecher", 0, bytedribble.Completed))
+	results, err := s.Scrape(ctx, hash, make([]byte, 20))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []bytedribble.ScrapeResult{{Complete: 1, Downloaded: 1}, {}}, results)
+	}
+	assert.Equal(t, [][]byte{hash}, s.InfoHashes())
+}
+
+func TestSwarms_Expiry(t *testing.T) {
+	s := NewSwarms(time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, _ = s.Announce(ctx, testAnnounce("old", 10, bytedribble.Started))
+	now = now.Add(90 * time.Second)
+	_, _ = s.Announce(ctx, testAnnounce("new", 10, bytedribble.Started))
+	now = now.Add(90 * time.Second)
+
+	resp, err := s.Announce(ctx, testAnnounce("newer", 10, bytedribble.Started))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Leechers)
+	if assert.Len(t, resp.Peers, 1) {
+		assert.Equal(t, bytedribble.PeerIDFromString("new"), resp.Peers[0].PeerID)
+	}
+}
+
+func TestSwarms_Traffic(t *testing.T) {
+	s := NewSwarms(time.Minute)
+	ctx := context.Background()
+	announce := func(event bytedribble.Event, uploaded, downloaded int64) traffic {
+		req := testAnnounce("peer", 10, event)
+		req.Uploaded, req.Downloaded = uploaded, downloaded
+		_, tr, err := s.record(ctx, req)
+		assert.NoError(t, err)
+		return tr
+	}
+
+	// a forgotten peer's report only sets a baseline
+	assert.Equal(t, traffic{}, announce(bytedribble.Empty, 100, 100))
+	assert.Equal(t, traffic{uploaded: 10, downloaded: 20}, announce(bytedribble.Empty, 110, 120))
+	// the client's totals were reset
+	assert.Equal(t, traffic{uploaded: 5, downloaded: 0}, announce(bytedribble.Empty, 5, 0))
+	assert.Equal(t, traffic{uploaded: 5, downloaded: 5}, announce(bytedribble.Stopped, 10, 5))
+	assert.Equal(t, traffic{uploaded: 1, downloaded: 2}, announce(bytedribble.Started, 1, 2))
+}
+
+func TestSwarms_PeerIdentity(t *testing.T) {
+	s := NewSwarms(time.Minute)
+	ctx := context.Background()
+	_, err := s.Announce(ctx, testAnnounce("victim", 10, bytedribble.Started))
+	assert.NoError(t, err)
+
+	// another host reusing the peer id neither stops nor moves the peer
+	req := testAnnounce("victim", 10, bytedribble.Stopped)
+	req.IP = net.IPv4(10, 0, 0, 2)
+	_, err = s.Announce(ctx, req)
+	assert.NoError(t, err)
+	req.Event, req.Port = bytedribble.Started, 1234
+	_, err = s.Announce(ctx, req)
+	assert.NoError(t, err)
+
+	resp, err := s.Announce(ctx, testAnnounce("other", 10, bytedribble.Started))
+	assert.NoError(t, err)
+	var addrs []string
+	for _, p := range resp.Peers {
+		addrs = append(addrs, p.Addr())
+	}
+	assert.ElementsMatch(t, []string{"10.0.0.1:6881", "10.0.0.2:1234"}, addrs)
+}
+
+func TestSwarms_Forget(t *testing.T) {
+	s := NewSwarms(time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// stopping never creates a swarm, and stopping the last peer removes it
+	_, err := s.Announce(ctx, testAnnounce("peer", 10, bytedribble.Stopped))
+	assert.NoError(t, err)
+	assert.Empty(t, s.InfoHashes())
+	_, _ = s.Announce(ctx, testAnnounce("peer", 10, bytedribble.Started))
+	assert.Len(t, s.InfoHashes(), 1)
+	_, _ = s.Announce(ctx, testAnnounce("peer", 10, bytedribble.Stopped))
+	assert.Empty(t, s.InfoHashes())
+
+	// swarms whose peers all expired are removed without being announced to again
+	for _, h := range []string{"bbbbbbbbbbbbbbbbbbbb", "cccccccccccccccccccc"} {
+		req := testAnnounce("peer", 10, bytedribble.Started)
+		req.InfoHash = []byte(h)
+		_, _ = s.Announce(ctx, req)
+	}
+	now = now.Add(3 * time.Minute)
+	_, _ = s.Announce(ctx, testAnnounce("peer", 10, bytedribble.Started))
+	s.mu.Lock()
+	assert.Len(t, s.torrents, 1)
+	s.mu.Unlock()
+
+	// the download count outlives the swarm
+	_, _ = s.Announce(ctx, testAnnounce("peer", 0, bytedribble.Completed))
+	_, _ = s.Announce(ctx, testAnnounce("peer", 0, bytedribble.Stopped))
+	assert.Empty(t, s.InfoHashes())
+	results, err := s.Scrape(ctx, testAnnounce("peer", 0, bytedribble.Empty).InfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []bytedribble.ScrapeResult{{Downloaded: 1}}, results)
+	}
+}
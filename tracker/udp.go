@@ -0,0 +1,272 @@
+package tracker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	udpProtocolID = 0x41727101980 // magic constant identifying connect requests
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	// udpSecretLifetime is how often the secret behind connection IDs changes. IDs made with the current or previous
+	// secret are accepted, so each one lasts at least this long.
+	udpSecretLifetime = 2 * time.Minute
+	// udpMaxScrape is the most infohashes that fit in one scrape request.
+	udpMaxScrape = 74
+	// udpAnnounceLen is the length of an announce request up to the port, after which BEP-41 options may follow.
+	udpAnnounceLen = 98
+
+	udpOptionEnd     = 0
+	udpOptionNOP     = 1
+	udpOptionURLData = 2 // carries the path and query of the tracker URL
+)
+
+// udpEvents maps the event numbers of the UDP protocol to announce events.
+var udpEvents = map[uint32]bytedribble.Event{
+	0: bytedribble.Empty,
+	1: bytedribble.Completed,
+	2: bytedribble.Started,
+	3: bytedribble.Stopped,
+}
+
+// UDPServer serves the UDP tracker protocol from a set of swarms.
+//
+// Connection IDs are not stored: each is a MAC of the client's IP address under a secret that is replaced
+// periodically, so that any ID can be checked but only the client it was issued to can use it. The port is left out,
+// as clients may send each request from a new socket.
+//
+// Like Handler, peers are recorded at the address their request came from and the IP address field is ignored.
+//
+// See: https://www.bittorrent.org/beps/bep_0015.html
+type UDPServer struct {
+	backend backend
+	now     func() time.Time
+
+	mu         sync.Mutex
+	secret     []byte
+	prevSecret []byte
+	rotated    time.Time // when secret was made
+}
+
+func NewUDPServer(swarms *Swarms) *UDPServer {
+	return &UDPServer{
+		backend: swarms,
+		now:     time.Now,
+	}
+}
+
+// NewPrivateUDPServer returns a server for a private tracker, which expects announce URLs of the form
+// udp://host/<passkey>/announce. Clients send the passkey with each announce as BEP-41 URL data. Scrapes carry no
+// URL data, and so are refused.
+//
+// See: https://www.bittorrent.org/beps/bep_0041.html
+func NewPrivateUDPServer(private *Private) *UDPServer {
+	return &UDPServer{
+		backend: private,
+		now:     time.Now,
+	}
+}
+
+// Serve answers the requests arriving on conn until reading from it fails, and returns that error.
+func (s *UDPServer) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if resp := s.handle(buf[:n], udpAddr); resp != nil {
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handle returns the response to a single request from addr, or nil if it is not worth answering.
+func (s *UDPServer) handle(req []byte, addr *net.UDPAddr) []byte {
+	if len(req) < 16 {
+		return nil
+	}
+	connID, action, txID := binary.BigEndian.Uint64(req), binary.BigEndian.Uint32(req[8:]), req[12:16]
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	resp = append(resp, txID...)
+
+	if action == udpActionConnect {
+		if connID != udpProtocolID {
+			return nil
+		}
+		return binary.BigEndian.AppendUint64(resp, s.connectionID(addr))
+	}
+	if !s.validConnectionID(connID, addr) {
+		return udpError(txID, "invalid connection id")
+	}
+	var err error
+	switch action {
+	case udpActionAnnounce:
+		resp, err = s.announce(resp, req[16:], addr)
+	case udpActionScrape:
+		resp, err = s.scrape(resp, req[16:])
+	default:
+		err = errors.New("unknown action")
+	}
+	if err != nil {
+		return udpError(txID, err.Error())
+	}
+	return resp
+}
+
+// announce appends the response to an announce request with the given body to resp. Peers are only returned if they
+// are of the same IP address family as the requester, since the response cannot say which family each peer is.
+func (s *UDPServer) announce(resp, body []byte, addr *net.UDPAddr) ([]byte, error) {
+	if len(body) < udpAnnounceLen-16 {
+		return nil, errors.New("announce request is too short")
+	}
+	ip := append(net.IP(nil), addr.IP...)
+	ipLen := net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, ipLen = ip4, net.IPv4len
+	}
+	event, ok := udpEvents[binary.BigEndian.Uint32(body[64:])]
+	if !ok {
+		event = bytedribble.Empty
+	}
+	req := bytedribble.AnnounceRequest{
+		InfoHash:   body[0:20],
+		PeerID:     bytedribble.PeerIDFromString(string(body[20:40])),
+		IP:         ip,
+		Port:       int(binary.BigEndian.Uint16(body[80:])),
+		Downloaded: int64(binary.BigEndian.Uint64(body[40:])),
+		Left:       int64(binary.BigEndian.Uint64(body[48:])),
+		Uploaded:   int64(binary.BigEndian.Uint64(body[56:])),
+		Event:      event,
+		NumWant:    int(int32(binary.BigEndian.Uint32(body[76:]))),
+	}
+	if req.NumWant < 0 {
+		req.NumWant = -1
+	}
+	urlData, err := parseURLData(body[udpAnnounceLen-16:])
+	if err != nil {
+		return nil, err
+	}
+	ar, err := s.backend.announce(context.Background(), passkeyFromPath(urlData), req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = binary.BigEndian.AppendUint32(resp, uint32(intervalSeconds(ar.Interval)))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(ar.Leechers))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(ar.Seeders))
+	for _, p := range ar.Peers {
+		if ip4 := p.IP.To4(); ipLen == net.IPv4len && ip4 != nil {
+			resp = appendCompactPeer(resp, ip4, p.Port)
+		} else if ipLen == net.IPv6len && ip4 == nil {
+			resp = appendCompactPeer(resp, p.IP.To16(), p.Port)
+		}
+	}
+	return resp, nil
+}
+
+// scrape appends the response to a scrape request with the given body of concatenated infohashes to resp.
+func (s *UDPServer) scrape(resp, body []byte) ([]byte, error) {
+	if len(body) == 0 || len(body)%20 != 0 || len(body)/20 > udpMaxScrape {
+		return nil, errors.New("invalid scrape request")
+	}
+	var infoHashes [][]byte
+	for i := 0; i < len(body); i += 20 {
+		infoHashes = append(infoHashes, body[i:i+20])
+	}
+	_, results, err := s.backend.scrape(context.Background(), "", infoHashes)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		resp = binary.BigEndian.AppendUint32(resp, uint32(r.Complete))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(r.Downloaded))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(r.Incomplete))
+	}
+	return resp, nil
+}
+
+// parseURLData returns the path and query carried by the BEP-41 options following an announce request.
+func parseURLData(options []byte) (string, error) {
+	var data []byte
+	for len(options) > 0 {
+		switch options[0] {
+		case udpOptionEnd:
+			return string(data), nil
+		case udpOptionNOP:
+			options = options[1:]
+		case udpOptionURLData:
+			if len(options) < 2 || len(options) < 2+int(options[1]) {
+				return "", errors.New("truncated announce option")
+			}
+			n := 2 + int(options[1])
+			data = append(data, options[2:n]...)
+			options = options[n:]
+		default:
+			return "", errors.New("unknown announce option")
+		}
+	}
+	return string(data), nil
+}
+
+func udpError(txID []byte, message string) []byte {
+	resp := binary.BigEndian.AppendUint32(nil, udpActionError)
+	resp = append(resp, txID...)
+	return append(resp, message...)
+}
+
+// connectionID returns the connection ID for a client at addr under the current secret.
+func (s *UDPServer) connectionID(addr *net.UDPAddr) uint64 {
+	secret, _ := s.secrets()
+	return udpMAC(secret, addr)
+}
+
+// validConnectionID reports whether id was issued to a client at addr under the current or previous secret.
+func (s *UDPServer) validConnectionID(id uint64, addr *net.UDPAddr) bool {
+	secret, prev := s.secrets()
+	return id == udpMAC(secret, addr) || (prev != nil && id == udpMAC(prev, addr))
+}
+
+// secrets returns the current and previous secrets, rotating them first if the current one is too old.
+func (s *UDPServer) secrets() (current, prev []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if age := now.Sub(s.rotated); s.secret == nil || age >= udpSecretLifetime {
+		s.prevSecret = s.secret
+		if age >= 2*udpSecretLifetime {
+			// IDs made with the previous secret have expired too
+			s.prevSecret = nil
+		}
+		s.secret = make([]byte, 32)
+		if _, err := rand.Read(s.secret); err != nil {
+			panic(err)
+		}
+		s.rotated = now
+	}
+	return s.secret, s.prevSecret
+}
+
+// udpMAC returns the connection ID for a client at addr under secret.
+func udpMAC(secret []byte, addr *net.UDPAddr) uint64 {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(addr.IP.To16())
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
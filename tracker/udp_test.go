@@ -0,0 +1,100 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/bunsenmcdubbs/bytedribble"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestUDPServer(t *testing.T, swarms *Swarms) (*UDPServer, *bytedribble.UDPTracker) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	s := NewUDPServer(swarms)
+	go func() { _ = s.Serve(conn) }()
+
+	u, err := url.Parse("udp://" + conn.LocalAddr().String() + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, bytedribble.NewUDPTracker(u)
+}
+
+func TestUDPServer_Announce(t *testing.T) {
+	_, client := newTestUDPServer(t, NewSwarms(time.Minute))
+	ctx := context.Background()
+
+	_, err := client.Announce(ctx, testAnnounce("-DR0001-000000000001", 0, bytedribble.Started))
+	assert.NoError(t, err)
+	req := testAnnounce("-DR0001-000000000002", 10, bytedribble.Started)
+	req.Port = 6882
+	resp, err := client.Announce(ctx, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, time.Minute, resp.Interval)
+	assert.Equal(t, 1, resp.Seeders)
+	assert.Equal(t, 1, resp.Leechers)
+	if assert.Len(t, resp.Peers, 1) {
+		// recorded at the address the request came from
+		assert.Equal(t, "127.0.0.1:6881", resp.Peers[0].Addr())
+	}
+
+	results, err := client.Scrape(ctx, req.InfoHash, make([]byte, 20))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []bytedribble.ScrapeResult{{Complete: 1, Incomplete: 1}, {}}, results)
+	}
+
+	req.Port = 0
+	_, err = client.Announce(ctx, req)
+	var failure *bytedribble.TrackerFailureError
+	if assert.ErrorAs(t, err, &failure) {
+		assert.Equal(t, "invalid port", failure.Reason)
+	}
+}
+
+func TestUDPServer_ShortInterval(t *testing.T) {
+	swarms := NewSwarms(time.Minute)
+	swarms.Interval = 10 * time.Millisecond
+	_, client := newTestUDPServer(t, swarms)
+	resp, err := client.Announce(context.Background(), testAnnounce("-DR0001-000000000001", 0, bytedribble.Started))
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Second, resp.Interval)
+	}
+}
+
+func TestUDPServer_ConnectionID(t *testing.T) {
+	s := NewUDPServer(NewSwarms(time.Minute))
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	other := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1234}
+
+	connect := binary.BigEndian.AppendUint64(nil, udpProtocolID)
+	connect = append(connect, 0, 0, 0, udpActionConnect, 1, 2, 3, 4)
+	resp := s.handle(connect, addr)
+	if !assert.Len(t, resp, 16) {
+		return
+	}
+	assert.Equal(t, []byte{0, 0, 0, udpActionConnect, 1, 2, 3, 4}, resp[:8])
+	id := binary.BigEndian.Uint64(resp[8:])
+
+	assert.True(t, s.validConnectionID(id, addr))
+	assert.False(t, s.validConnectionID(id, other))
+	now = now.Add(udpSecretLifetime)
+	assert.True(t, s.validConnectionID(id, addr), "valid under the previous secret")
+	now = now.Add(udpSecretLifetime)
+	assert.False(t, s.validConnectionID(id, addr))
+
+	scrape := binary.BigEndian.AppendUint64(nil, id)
+	scrape = append(scrape, 0, 0, 0, udpActionScrape, 1, 2, 3, 4)
+	scrape = append(scrape, make([]byte, 20)...)
+	assert.Equal(t, append([]byte{0, 0, 0, udpActionError, 1, 2, 3, 4}, "invalid connection id"...), s.handle(scrape, addr))
+}
@@ -0,0 +1,264 @@
+package bytedribble
+
+import (
+	"context"
+	"github.com/bunsenmcdubbs/bytedribble/bencoding"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTrackerClient(t *testing.T, handler http.HandlerFunc) *TrackerClient {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := Metainfo{TrackerURL: u, RawInfo: bencoding.RawMessage("de")}
+	self := PeerInfo{PeerID: PeerIDFromString("-DR0001-000000000000"), Port: 6881}
+	return NewTrackerClient(NewTrackerRegistry(srv.Client()), meta, self, FakeMetrics{TotalSize: 10})
+}
+
+func TestTrackerClient_Announce(t *testing.T) {
+	c := newTestTrackerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "6881", r.URL.Query().Get("port"))
+		_, _ = w.Write([]byte("d8:intervali900e5:peersld2:ip9:127.0.0.17:peer id20:abcdefghijklmnopqrst4:porti6882eeee"))
+	})
+	interval, err := c.syncTracker(context.Background(), Started)
+	assert.NoError(t, err)
+	assert.Equal(t, 900*time.Second, interval)
+	peers := c.Peers()
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, "abcdefghijklmnopqrst", peers[0].PeerID.String())
+		assert.Equal(t, "127.0.0.1", peers[0].IP.String())
+		assert.Equal(t, 6882, peers[0].Port)
+	}
+}
+
+func TestTrackerClient_HostileResponse(t *testing.T) {
+	tests := map[string]string{
+		"deep nesting":    "d5:extra" + strings.Repeat("l", 1000) + strings.Repeat("e", 1000) + "8:intervali900e5:peerslee",
+		"huge string":     "d14:failure reason999999999999:",
+		"endless payload": "d8:intervali900e5:peersl" + strings.Repeat("d2:ip9:127.0.0.1e", 1<<18) + "ee",
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTestTrackerClient(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(body))
+			})
+			_, err := c.syncTracker(context.Background(), Started)
+			var limitErr *bencoding.LimitError
+			assert.ErrorAs(t, err, &limitErr)
+		})
+	}
+}
+
+func TestTrackerClient_AnnounceList(t *testing.T) {
+	var hits []string
+	newTracker := func(name string, ok bool) *url.URL {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			if !ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("d8:intervali900e5:peerslee"))
+		}))
+		t.Cleanup(srv.Close)
+		u, err := url.Parse(srv.URL + "/announce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	dead1, dead2, alive1, alive2 := newTracker("dead1", false), newTracker("dead2", false), newTracker("alive1", true), newTracker("alive2", true)
+	meta := Metainfo{
+		TrackerURL:   dead1,
+		AnnounceList: [][]*url.URL{{dead1, dead2}, {alive1, alive2}},
+		RawInfo:      bencoding.RawMessage("de"),
+	}
+	c := NewTrackerClient(nil, meta, PeerInfo{Port: 6881}, FakeMetrics{TotalSize: 10})
+
+	_, err := c.syncTracker(context.Background(), Started)
+	assert.NoError(t, err)
+	// both trackers in the first tier are tried before falling through to the second tier
+	if assert.Len(t, hits, 3) {
+		assert.ElementsMatch(t, []string{"dead1", "dead2"}, hits[:2])
+	}
+	winner := hits[2]
+
+	// the tracker that responded is now first in its tier and is tried first next time (after the dead tier)
+	hits = nil
+	_, err = c.syncTracker(context.Background(), Empty)
+	assert.NoError(t, err)
+	assert.Equal(t, winner, hits[len(hits)-1])
+	assert.Len(t, hits, 3)
+
+	statuses := c.TrackerStatus()
+	if assert.Len(t, statuses, 4) {
+		assert.Error(t, statuses[0].LastError)
+		assert.Equal(t, 0, statuses[0].Tier)
+		assert.Equal(t, 1, statuses[2].Tier)
+		assert.NoError(t, statuses[2].LastError)
+		assert.Equal(t, 900*time.Second, statuses[2].Interval)
+		assert.False(t, statuses[2].LastAnnounce.IsZero())
+		assert.True(t, statuses[3].LastAnnounce.IsZero())
+	}
+}
+
+func TestTrackerClient_AllTrackersFail(t *testing.T) {
+	c := newTestTrackerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("d14:failure reason4:nopee"))
+	})
+	_, err := c.syncTracker(context.Background(), Started)
+	assert.ErrorContains(t, err, "tracker returned error: nope")
+	assert.Error(t, c.TrackerStatus()[0].LastError)
+}
+
+func TestTrackerClient_CompactPeers(t *testing.T) {
+	peers := string([]byte{127, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2})
+	peers6 := string(append(net.ParseIP("::1"), 0x1a, 0xe3))
+	c := newTestTrackerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1", r.URL.Query().Get("compact"))
+		_, _ = w.Write([]byte("d8:intervali900e5:peers12:" + peers + "6:peers618:" + peers6 + "e"))
+	})
+	_, err := c.syncTracker(context.Background(), Started)
+	assert.NoError(t, err)
+	var addrs []string
+	for _, p := range c.Peers() {
+		assert.Equal(t, PeerID{}, p.PeerID)
+		addrs = append(addrs, p.Addr())
+	}
+	assert.Equal(t, []string{"127.0.0.1:6881", "10.0.0.2:6882", "[::1]:6883"}, addrs)
+}
+
+func TestTrackerClient_CompactFallback(t *testing.T) {
+	var compacts []string
+	c := newTestTrackerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		compacts = append(compacts, r.URL.Query().Get("compact"))
+		if r.URL.Query().Get("compact") == "1" {
+			_, _ = w.Write([]byte("d14:failure reason19:compact unsupportede"))
+			return
+		}
+		// peer ids may be omitted from the dictionary model too
+		_, _ = w.Write([]byte("d8:intervali900e5:peersld2:ip9:127.0.0.14:porti6882eeee"))
+	})
+	_, err := c.syncTracker(context.Background(), Started)
+	assert.NoError(t, err)
+	if assert.Len(t, c.Peers(), 1) {
+		assert.Equal(t, PeerID{}, c.Peers()[0].PeerID)
+	}
+	_, err = c.syncTracker(context.Background(), Empty)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "0", "0"}, compacts)
+}
+
+func TestTrackerClient_NoCompactFallbackOnOtherFailures(t *testing.T) {
+	var compacts []string
+	c := newTestTrackerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		compacts = append(compacts, r.URL.Query().Get("compact"))
+		_, _ = w.Write([]byte("d14:failure reason20:unregistered torrente"))
+	})
+	_, err := c.syncTracker(context.Background(), Started)
+	var failure *TrackerFailureError
+	if assert.ErrorAs(t, err, &failure) {
+		assert.Equal(t, "unregistered torrent", failure.Reason)
+	}
+	assert.Equal(t, []string{"1"}, compacts)
+}
+
+func TestParseCompactPeers_Invalid(t *testing.T) {
+	_, err := parseCompactPeers(make([]byte, 7), net.IPv4len)
+	assert.Error(t, err)
+	_, err = parseCompactPeers([]byte{127, 0, 0, 1, 0, 0}, net.IPv4len)
+	assert.ErrorContains(t, err, "port")
+}
+
+func TestHTTPTracker_Announce(t *testing.T) {
+	var queries []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		_, _ = w.Write([]byte("d8:completei3e10:incompletei4e8:intervali900e12:min intervali60e5:peers0:10:tracker id3:abce"))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL + "/announce")
+	tracker := NewHTTPTracker(srv.Client(), u)
+
+	req := AnnounceRequest{InfoHash: make([]byte, 20), Port: 6881, IP: net.IPv4(10, 0, 0, 1), NumWant: 5}
+	resp, err := tracker.Announce(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 900*time.Second, resp.Interval)
+	assert.Equal(t, 60*time.Second, resp.MinInterval)
+	assert.Equal(t, 3, resp.Seeders)
+	assert.Equal(t, 4, resp.Leechers)
+	assert.Empty(t, resp.Peers)
+
+	_, err = tracker.Announce(context.Background(), req)
+	assert.NoError(t, err)
+	if assert.Len(t, queries, 2) {
+		assert.Equal(t, "5", queries[0].Get("numwant"))
+		assert.Equal(t, "10.0.0.1", queries[0].Get("ip"))
+		assert.Equal(t, "", queries[0].Get("trackerid"))
+		assert.Equal(t, "abc", queries[1].Get("trackerid"))
+	}
+}
+
+func TestScrapeURL(t *testing.T) {
+	// examples from BEP-48
+	for announce, want := range map[string]string{
+		"http://example.com/announce":          "http://example.com/scrape",
+		"http://example.com/x/announce":        "http://example.com/x/scrape",
+		"http://example.com/announce.php":      "http://example.com/scrape.php",
+		"http://example.com/a":                 "",
+		"http://example.com/announce?x2%0644":  "http://example.com/scrape?x2%0644",
+		"http://example.com/announce?x=2/4":    "http://example.com/scrape?x=2/4",
+		"http://example.com/x%064announce":     "",
+		"http://example.com/passkey/announce":  "http://example.com/passkey/scrape",
+		"http://example.com/announce/passkey/": "",
+	} {
+		u, err := url.Parse(announce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := scrapeURL(u)
+		if want == "" {
+			assert.ErrorIs(t, err, ErrScrapeUnsupported, announce)
+			continue
+		}
+		if assert.NoError(t, err, announce) {
+			assert.Equal(t, want, got.String())
+		}
+	}
+}
+
+func TestTrackerClient_Scrape(t *testing.T) {
+	a, b := strings.Repeat("a", 20), strings.Repeat("b", 20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/scrape", r.URL.Path)
+		assert.Equal(t, []string{a, b}, r.URL.Query()["info_hash"])
+		_, _ = w.Write([]byte("d5:filesd20:" + a + "d8:completei5e10:downloadedi50e10:incompletei10e4:name1:aeee"))
+	}))
+	t.Cleanup(srv.Close)
+	unsupported, _ := url.Parse(srv.URL + "/tracker")
+	supported, _ := url.Parse(srv.URL + "/announce")
+	meta := Metainfo{AnnounceList: [][]*url.URL{{unsupported}, {supported}}, RawInfo: []byte("de")}
+	c := NewTrackerClient(NewTrackerRegistry(srv.Client()), meta, PeerInfo{}, FakeMetrics{})
+
+	results, err := c.Scrape(context.Background(), []byte(a), []byte(b))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ScrapeResult{{Complete: 5, Downloaded: 50, Incomplete: 10, Name: "a"}, {}}, results)
+	}
+
+	meta.AnnounceList = [][]*url.URL{{unsupported}}
+	c = NewTrackerClient(NewTrackerRegistry(srv.Client()), meta, PeerInfo{}, FakeMetrics{})
+	_, err = c.Scrape(context.Background())
+	assert.ErrorIs(t, err, ErrScrapeUnsupported)
+}
@@ -0,0 +1,288 @@
+package bytedribble
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	udpProtocolID = 0x41727101980 // magic constant identifying connect requests
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	// udpConnectionIDLifetime is how long a client may use a connection ID.
+	udpConnectionIDLifetime = time.Minute
+	// udpMaxScrape is the most infohashes that fit in one scrape request.
+	udpMaxScrape = 74
+	// udpMaxPacket bounds the size of a tracker's response.
+	udpMaxPacket = 64 << 10
+
+	udpOptionURLData = 2 // BEP-41 option carrying the path and query of the tracker URL
+)
+
+// UDPTracker announces to, and scrapes, a single UDP tracker.
+//
+// See: https://www.bittorrent.org/beps/bep_0015.html
+// See: https://www.bittorrent.org/beps/bep_0041.html
+type UDPTracker struct {
+	url *url.URL
+	key uint32 // identifies this client to the tracker across IP address changes
+
+	timeout        time.Duration // to wait for the first response, doubling with each retransmission
+	maxRetransmits int
+
+	mu         sync.Mutex
+	connID     uint64
+	connExpiry time.Time
+}
+
+func NewUDPTracker(u *url.URL) *UDPTracker {
+	return &UDPTracker{
+		url:            u,
+		key:            rand.Uint32(),
+		timeout:        15 * time.Second,
+		maxRetransmits: 8,
+	}
+}
+
+// String returns the tracker's URL.
+func (t *UDPTracker) String() string {
+	return t.url.String()
+}
+
+// udpEvents maps announce events to their numbers in the UDP protocol.
+var udpEvents = map[Event]uint32{
+	Empty:     0,
+	Completed: 1,
+	Started:   2,
+	Stopped:   3,
+}
+
+// Announce announces the local peer to the tracker and returns the peers it responds with.
+func (t *UDPTracker) Announce(ctx context.Context, req AnnounceRequest) (AnnounceResponse, error) {
+	if len(req.InfoHash) != 20 {
+		return AnnounceResponse{}, errors.New("invalid infohash")
+	}
+	body := append([]byte(nil), req.InfoHash...)
+	body = append(body, req.PeerID[:]...)
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Downloaded))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Left))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Uploaded))
+	body = binary.BigEndian.AppendUint32(body, udpEvents[req.Event])
+	if ip4 := req.IP.To4(); ip4 != nil {
+		body = append(body, ip4...)
+	} else {
+		body = binary.BigEndian.AppendUint32(body, 0) // determined by the tracker
+	}
+	body = binary.BigEndian.AppendUint32(body, t.key)
+	body = binary.BigEndian.AppendUint32(body, uint32(int32(req.NumWant)))
+	body = binary.BigEndian.AppendUint16(body, uint16(req.Port))
+	body = t.appendURLData(body)
+
+	resp, addr, err := t.roundTrip(ctx, udpActionAnnounce, body)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	if len(resp) < 12 {
+		return AnnounceResponse{}, errors.New("announce response is too short")
+	}
+	interval := time.Duration(binary.BigEndian.Uint32(resp[0:])) * time.Second
+	if interval <= 0 {
+		return AnnounceResponse{}, errors.New("missing interval")
+	}
+	// trackers reached over IPv6 respond with IPv6 peers
+	ipLen := net.IPv4len
+	if addr.IP.To4() == nil {
+		ipLen = net.IPv6len
+	}
+	peers, err := parseCompactPeers(resp[12:], ipLen)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	return AnnounceResponse{
+		Interval: interval,
+		Leechers: int(binary.BigEndian.Uint32(resp[4:])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[8:])),
+		Peers:    peers,
+	}, nil
+}
+
+// appendURLData appends the path and query of the tracker URL as BEP-41 options, split into chunks of at most 255
+// bytes. Some trackers rely on it to carry a passkey.
+func (t *UDPTracker) appendURLData(buf []byte) []byte {
+	data := t.url.RequestURI()
+	if data == "/" {
+		return buf
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > 255 {
+			n = 255
+		}
+		buf = append(buf, udpOptionURLData, byte(n))
+		buf = append(buf, data[:n]...)
+		data = data[n:]
+	}
+	return buf
+}
+
+// Scrape returns the tracker's statistics for each of the infohashes, in order.
+func (t *UDPTracker) Scrape(ctx context.Context, infoHashes ...[]byte) ([]ScrapeResult, error) {
+	if len(infoHashes) == 0 || len(infoHashes) > udpMaxScrape {
+		return nil, fmt.Errorf("can only scrape 1 to %d infohashes at once", udpMaxScrape)
+	}
+	for _, h := range infoHashes {
+		if len(h) != 20 {
+			return nil, errors.New("invalid infohash")
+		}
+	}
+	var body []byte
+	for _, h := range infoHashes {
+		body = append(body, h...)
+	}
+	resp, _, err := t.roundTrip(ctx, udpActionScrape, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) != 12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response has %d bytes for %d infohashes", len(resp), len(infoHashes))
+	}
+	results := make([]ScrapeResult, len(infoHashes))
+	for i := range results {
+		entry := resp[12*i:]
+		results[i] = ScrapeResult{
+			Complete:   int(binary.BigEndian.Uint32(entry[0:])),
+			Downloaded: int(binary.BigEndian.Uint32(entry[4:])),
+			Incomplete: int(binary.BigEndian.Uint32(entry[8:])),
+		}
+	}
+	return results, nil
+}
+
+// roundTrip sends a request with the given action and body, and returns the body of the tracker's response and the
+// tracker's address. A connection ID is obtained first if needed. Unanswered requests are retransmitted after
+// 15 * 2^n seconds, for n up to 8.
+func (t *UDPTracker) roundTrip(ctx context.Context, action uint32, body []byte) ([]byte, *net.UDPAddr, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", t.url.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	addr := conn.RemoteAddr().(*net.UDPAddr)
+
+	// interrupt reads once the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	for n := 0; n <= t.maxRetransmits; n++ {
+		timeout := t.timeout << n
+		connID, ok := t.connectionID()
+		if !ok {
+			resp, err := t.exchange(ctx, conn, udpProtocolID, udpActionConnect, nil, timeout)
+			if errors.Is(err, errUDPTimeout) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(resp) < 8 {
+				return nil, nil, errors.New("connect response is too short")
+			}
+			connID = binary.BigEndian.Uint64(resp)
+			t.setConnectionID(connID)
+		}
+
+		resp, err := t.exchange(ctx, conn, connID, action, body, timeout)
+		if errors.Is(err, errUDPTimeout) {
+			continue
+		}
+		return resp, addr, err
+	}
+	return nil, nil, fmt.Errorf("UDP tracker %s did not respond", t.url.Host)
+}
+
+var errUDPTimeout = errors.New("UDP tracker timed out")
+
+// exchange sends a single request and waits up to timeout for the matching response. The request is made of the
+// connection ID (or protocol ID when connecting), action, a new transaction ID and body. Responses to other
+// transactions are ignored.
+func (t *UDPTracker) exchange(ctx context.Context, conn net.Conn, connID uint64, action uint32, body []byte, timeout time.Duration) ([]byte, error) {
+	txID := rand.Uint32()
+	req := binary.BigEndian.AppendUint64(nil, connID)
+	req = binary.BigEndian.AppendUint32(req, action)
+	req = binary.BigEndian.AppendUint32(req, txID)
+	req = append(req, body...)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		// cancelled before the deadline was replaced
+		return nil, err
+	}
+	buf := make([]byte, udpMaxPacket)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, errUDPTimeout
+			}
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:]) != txID {
+			continue
+		}
+		switch got := binary.BigEndian.Uint32(buf); got {
+		case action:
+			return buf[8:n], nil
+		case udpActionError:
+			if action != udpActionConnect {
+				// the connection ID may have been rejected, so get a new one next time
+				t.setConnectionID(0)
+			}
+			return nil, &TrackerFailureError{Reason: string(buf[8:n])}
+		default:
+			return nil, fmt.Errorf("UDP tracker responded with action %d to action %d", got, action)
+		}
+	}
+}
+
+// connectionID returns the current connection ID, if there is one that has not expired.
+func (t *UDPTracker) connectionID() (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connID, t.connID != 0 && time.Now().Before(t.connExpiry)
+}
+
+// setConnectionID caches a connection ID, or forgets the current one if id is 0.
+func (t *UDPTracker) setConnectionID(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connID = id
+	t.connExpiry = time.Now().Add(udpConnectionIDLifetime)
+}
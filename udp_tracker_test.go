@@ -0,0 +1,226 @@
+package bytedribble
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker is an in-process stand-in for a UDP tracker.
+type fakeUDPTracker struct {
+	conn net.PacketConn
+
+	mu        sync.Mutex
+	drop      int    // number of requests to ignore
+	failure   string // if set, announces fail with this message
+	interval  uint32 // in announce responses
+	connects  int
+	announces [][]byte
+}
+
+const fakeConnID = 0x1122334455667788
+
+func newFakeUDPTracker(t *testing.T) (*fakeUDPTracker, *UDPTracker) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	f := &fakeUDPTracker{conn: conn, interval: 900}
+	go f.serve()
+
+	u, err := url.Parse("udp://" + conn.LocalAddr().String() + "/announce?passkey=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewUDPTracker(u)
+	tracker.timeout = 20 * time.Millisecond
+	tracker.maxRetransmits = 3
+	return f, tracker
+}
+
+func (f *fakeUDPTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		if resp := f.handle(req); resp != nil {
+			_, _ = f.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (f *fakeUDPTracker) handle(req []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.drop > 0 {
+		f.drop--
+		return nil
+	}
+	if len(req) < 16 {
+		return nil
+	}
+	connID, action, txID := binary.BigEndian.Uint64(req), binary.BigEndian.Uint32(req[8:]), req[12:16]
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	resp = append(resp, txID...)
+	switch {
+	case action == udpActionConnect && connID == udpProtocolID:
+		f.connects++
+		return binary.BigEndian.AppendUint64(resp, fakeConnID)
+	case connID != fakeConnID:
+		return nil
+	case f.failure != "":
+		resp = binary.BigEndian.AppendUint32(nil, udpActionError)
+		return append(append(resp, txID...), f.failure...)
+	case action == udpActionAnnounce:
+		f.announces = append(f.announces, req[16:])
+		resp = binary.BigEndian.AppendUint32(resp, f.interval)
+		resp = binary.BigEndian.AppendUint32(resp, 1) // leechers
+		resp = binary.BigEndian.AppendUint32(resp, 2) // seeders
+		return append(resp, 10, 0, 0, 1, 0x1a, 0xe1)
+	case action == udpActionScrape:
+		for i := 16; i+20 <= len(req); i += 20 {
+			resp = append(resp, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0, byte(i))
+		}
+		return resp
+	}
+	return nil
+}
+
+func testAnnounceRequest() AnnounceRequest {
+	return AnnounceRequest{
+		InfoHash: make([]byte, 20),
+		PeerID:   PeerIDFromString("-DR0001-000000000000"),
+		Port:     6881,
+		Left:     10,
+		Event:    Started,
+		NumWant:  -1,
+	}
+}
+
+func TestUDPTracker_Announce(t *testing.T) {
+	f, tracker := newFakeUDPTracker(t)
+	resp, err := tracker.Announce(context.Background(), testAnnounceRequest())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 900*time.Second, resp.Interval)
+	assert.Equal(t, 1, resp.Leechers)
+	assert.Equal(t, 2, resp.Seeders)
+	if assert.Len(t, resp.Peers, 1) {
+		assert.Equal(t, "10.0.0.1:6881", resp.Peers[0].Addr())
+	}
+
+	// the connection ID is reused
+	_, err = tracker.Announce(context.Background(), testAnnounceRequest())
+	assert.NoError(t, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal(t, 1, f.connects)
+	if assert.Len(t, f.announces, 2) {
+		body := f.announces[0]
+		assert.Equal(t, "-DR0001-000000000000", string(body[20:40]))
+		assert.Equal(t, uint64(10), binary.BigEndian.Uint64(body[48:]))   // left
+		assert.Equal(t, uint32(2), binary.BigEndian.Uint32(body[64:]))    // started
+		assert.Equal(t, uint16(6881), binary.BigEndian.Uint16(body[80:])) // port
+		// BEP-41 URL data
+		assert.Equal(t, append([]byte{udpOptionURLData, 24}, "/announce?passkey=secret"...), body[82:])
+	}
+}
+
+func TestUDPTracker_ConnectionIDExpiry(t *testing.T) {
+	f, tracker := newFakeUDPTracker(t)
+	_, err := tracker.Announce(context.Background(), testAnnounceRequest())
+	assert.NoError(t, err)
+	tracker.mu.Lock()
+	tracker.connExpiry = time.Now().Add(-time.Second)
+	tracker.mu.Unlock()
+	_, err = tracker.Announce(context.Background(), testAnnounceRequest())
+	assert.NoError(t, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal(t, 2, f.connects)
+}
+
+func TestUDPTracker_Retransmit(t *testing.T) {
+	f, tracker := newFakeUDPTracker(t)
+	f.mu.Lock()
+	f.drop = 2 // the first connect and the first announce
+	f.mu.Unlock()
+	_, err := tracker.Announce(context.Background(), testAnnounceRequest())
+	assert.NoError(t, err)
+
+	f.mu.Lock()
+	f.drop = 100
+	f.mu.Unlock()
+	start := time.Now()
+	_, err = tracker.Announce(context.Background(), testAnnounceRequest())
+	assert.ErrorContains(t, err, "did not respond")
+	// 20ms, 40ms, 80ms and 160ms
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
+
+func TestUDPTracker_Cancel(t *testing.T) {
+	f, tracker := newFakeUDPTracker(t)
+	tracker.timeout = time.Hour
+	f.mu.Lock()
+	f.drop = 100
+	f.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := tracker.Announce(ctx, testAnnounceRequest())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUDPTracker_Error(t *testing.T) {
+	f, tracker := newFakeUDPTracker(t)
+	f.mu.Lock()
+	f.failure = "unregistered torrent"
+	f.mu.Unlock()
+	_, err := tracker.Announce(context.Background(), testAnnounceRequest())
+	var failure *TrackerFailureError
+	if assert.ErrorAs(t, err, &failure) {
+		assert.Equal(t, "unregistered torrent", failure.Reason)
+	}
+	// the connection ID is discarded after an error
+	_, ok := tracker.connectionID()
+	assert.False(t, ok)
+}
+
+func TestUDPTracker_ZeroInterval(t *testing.T) {
+	f, tracker := newFakeUDPTracker(t)
+	f.mu.Lock()
+	f.interval = 0
+	f.mu.Unlock()
+	_, err := tracker.Announce(context.Background(), testAnnounceRequest())
+	assert.ErrorContains(t, err, "missing interval")
+}
+
+func TestUDPTracker_Scrape(t *testing.T) {
+	_, tracker := newFakeUDPTracker(t)
+	results, err := tracker.Scrape(context.Background(), make([]byte, 20), make([]byte, 20))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ScrapeResult{{Complete: 3, Downloaded: 4, Incomplete: 16}, {Complete: 3, Downloaded: 4, Incomplete: 36}}, results)
+	}
+	_, err = tracker.Scrape(context.Background(), make([][]byte, udpMaxScrape+1)...)
+	assert.Error(t, err)
+}
+
+func TestTrackerClient_UDP(t *testing.T) {
+	_, tracker := newFakeUDPTracker(t)
+	meta := Metainfo{TrackerURL: tracker.url, RawInfo: []byte("de")}
+	c := NewTrackerClient(nil, meta, PeerInfo{Port: 6881}, FakeMetrics{TotalSize: 10})
+	c.announcers[tracker.url.String()] = tracker
+	interval, err := c.syncTracker(context.Background(), Started)
+	assert.NoError(t, err)
+	assert.Equal(t, 900*time.Second, interval)
+	assert.Len(t, c.Peers(), 1)
+}
@@ -0,0 +1,120 @@
+package bytedribble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WebSeed downloads pieces from an HTTP server hosting the torrent's files, as an alternative to peers. Only HTTP and
+// HTTPS web seeds are supported: the Downloader ignores url-list entries with any other scheme, such as ftp://.
+//
+// See: https://www.bittorrent.org/beps/bep_0019.html
+type WebSeed struct {
+	client *http.Client
+	url    *url.URL
+	target Metainfo
+}
+
+func NewWebSeed(client *http.Client, u *url.URL, target Metainfo) *WebSeed {
+	return &WebSeed{
+		client: client,
+		url:    u,
+		target: target,
+	}
+}
+
+// String returns the web seed's URL.
+func (w *WebSeed) String() string {
+	return w.url.String()
+}
+
+// fileURL returns the URL of the file at index idx. For a multi-file torrent this is the web seed URL followed by the
+// torrent's name and the file's path. For a single-file torrent it is the web seed URL itself, unless that names a
+// directory, in which case the torrent's name is appended.
+func (w *WebSeed) fileURL(idx int) string {
+	base := w.url.String()
+	if w.target.Files == nil {
+		if strings.HasSuffix(base, "/") {
+			return base + url.PathEscape(w.target.Name)
+		}
+		return base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	parts := []string{url.PathEscape(w.target.Name)}
+	for _, elem := range w.target.Files[idx].Path {
+		parts = append(parts, url.PathEscape(elem))
+	}
+	return base + strings.Join(parts, "/")
+}
+
+// Download fetches the piece's missing blocks and verifies the piece against its hashes.
+func (w *WebSeed) Download(ctx context.Context, p *Piece) error {
+	data := make([]byte, 0, p.Size)
+	for _, span := range w.target.FileSpans(int(p.Index)) {
+		if w.target.Files != nil && w.target.Files[span.FileIndex].IsPadding() {
+			// padding files are not hosted by web seeds
+			data = append(data, make([]byte, span.Length)...)
+			continue
+		}
+		var err error
+		if data, err = w.fetch(ctx, w.fileURL(span.FileIndex), span.FileOffset, span.Length, data); err != nil {
+			return err
+		}
+	}
+	if len(data) != int(p.Size) {
+		return fmt.Errorf("web seed returned %d bytes for piece %d of size %d", len(data), p.Index, p.Size)
+	}
+	for _, b := range p.MissingBlocks() {
+		p.AddBlockPayload(b, data[b.BeginOffset:b.BeginOffset+b.Length])
+	}
+	if !p.Valid() {
+		return fmt.Errorf("web seed returned invalid data for piece %d", p.Index)
+	}
+	return nil
+}
+
+// fetch appends length bytes starting at offset of the file at fileURL to buf.
+func (w *WebSeed) fetch(ctx context.Context, fileURL string, offset, length int64, buf []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return buf, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return buf, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range, so skip to the requested bytes
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return buf, fmt.Errorf("web seed file %s is too short: %w", fileURL, err)
+		}
+	default:
+		return buf, fmt.Errorf("web seed responded with unexpected HTTP status code: %d", resp.StatusCode)
+	}
+
+	start := len(buf)
+	if int64(cap(buf)-start) < length {
+		return buf, errors.New("piece is smaller than its files")
+	}
+	buf = buf[:start+int(length)]
+	if _, err := io.ReadFull(resp.Body, buf[start:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf[:start], fmt.Errorf("web seed file %s is too short: %w", fileURL, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,131 @@
+package bytedribble
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newWebSeedTorrent writes files beneath root/name, creates a torrent for them and returns it along with the
+// concatenated file contents.
+func newWebSeedTorrent(t *testing.T, root, name string, files map[string]string) (Metainfo, []byte) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	for file, contents := range files {
+		p := path
+		if file != "" {
+			p = filepath.Join(path, filepath.FromSlash(file))
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := MetainfoBuilder{
+		Path:           path,
+		AnnounceURLs:   []string{"http://tracker/announce"},
+		PieceSizeBytes: 16,
+	}.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseMetainfo(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	if meta.Files == nil {
+		data = []byte(files[""])
+	}
+	for _, f := range meta.Files {
+		b, err := os.ReadFile(filepath.Join(append([]string{path}, f.Path...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+	return meta, data
+}
+
+func downloadAll(t *testing.T, s seed, meta Metainfo) ([]byte, error) {
+	t.Helper()
+	var data []byte
+	for i := 0; i < meta.NumPieces(); i++ {
+		p := meta.newPiece(i)
+		if err := s.Download(context.Background(), p); err != nil {
+			return nil, err
+		}
+		data = append(data, p.Payload()...)
+	}
+	return data, nil
+}
+
+func TestWebSeed_Download(t *testing.T) {
+	root := t.TempDir()
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	t.Cleanup(srv.Close)
+
+	t.Run("single file", func(t *testing.T) {
+		meta, want := newWebSeedTorrent(t, root, "single.txt", map[string]string{"": "the quick brown fox jumps over the lazy dog"})
+		for _, u := range []string{srv.URL + "/", srv.URL + "/single.txt"} {
+			seedURL, _ := url.Parse(u)
+			got, err := downloadAll(t, NewWebSeed(srv.Client(), seedURL, meta), meta)
+			assert.NoError(t, err, u)
+			assert.Equal(t, want, got, u)
+		}
+	})
+
+	t.Run("multiple files", func(t *testing.T) {
+		meta, want := newWebSeedTorrent(t, root, "multi dir", map[string]string{
+			"a.txt":           "aaaaaaaaaaaaaaaaaaaaaaaaa",
+			"sub/b c.txt":     "b",
+			"sub/deeper/d.md": "ddddddddddddddddddddddddddddddddddddddddd",
+		})
+		seedURL, _ := url.Parse(srv.URL)
+		got, err := downloadAll(t, NewWebSeed(srv.Client(), seedURL, meta), meta)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+func TestWebSeed_IgnoredRange(t *testing.T) {
+	contents := "0123456789abcdefghijklmnopqrstuvwxyz"
+	meta, want := newWebSeedTorrent(t, t.TempDir(), "file", map[string]string{"": contents})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(contents))
+	}))
+	t.Cleanup(srv.Close)
+
+	seedURL, _ := url.Parse(srv.URL + "/file")
+	got, err := downloadAll(t, NewWebSeed(srv.Client(), seedURL, meta), meta)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestWebSeed_BadData(t *testing.T) {
+	root := t.TempDir()
+	meta, _ := newWebSeedTorrent(t, root, "file", map[string]string{"": "0123456789abcdefghijklmnopqrstuvwxyz"})
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("0123456789abcdefGHIJKLMNOPQRSTUVWXYZ"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	t.Cleanup(srv.Close)
+
+	seedURL, _ := url.Parse(srv.URL + "/")
+	ws := NewWebSeed(srv.Client(), seedURL, meta)
+	assert.NoError(t, ws.Download(context.Background(), meta.newPiece(0)))
+	assert.ErrorContains(t, ws.Download(context.Background(), meta.newPiece(1)), "invalid data")
+
+	seedURL, _ = url.Parse(srv.URL + "/missing")
+	ws = NewWebSeed(srv.Client(), seedURL, meta)
+	assert.ErrorContains(t, ws.Download(context.Background(), meta.newPiece(0)), "404")
+}